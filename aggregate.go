@@ -0,0 +1,339 @@
+package styx
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	ld "github.com/piprate/json-gold/ld"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// An Aggregate is a function over the values of a variable in a group of solutions
+type Aggregate struct {
+	op       string
+	node     rdf.Term
+	distinct bool
+}
+
+// Count returns an aggregate that counts the solutions in which the
+// variable has a value, or all of the solutions if node is nil.
+func Count(node rdf.Term) *Aggregate { return &Aggregate{"COUNT", node, false} }
+
+// CountDistinct returns an aggregate that counts the different values of the variable
+func CountDistinct(node rdf.Term) *Aggregate { return &Aggregate{"COUNT", node, true} }
+
+// Min returns an aggregate for the least value of the variable. Literals are
+// ordered like they are in filters, and come after IRIs and blank nodes.
+func Min(node rdf.Term) *Aggregate { return &Aggregate{"MIN", node, true} }
+
+// Max returns an aggregate for the greatest value of the variable
+func Max(node rdf.Term) *Aggregate { return &Aggregate{"MAX", node, true} }
+
+// Sum returns an aggregate for the sum of the numeric values of the variable.
+// The sum is unbound if any of the values isn't a number.
+func Sum(node rdf.Term) *Aggregate { return &Aggregate{"SUM", node, false} }
+
+// Avg returns an aggregate for the average of the numeric values of the variable.
+// The average is unbound if any of the values isn't a number.
+func Avg(node rdf.Term) *Aggregate { return &Aggregate{"AVG", node, false} }
+
+// An aggregation is the state of an aggregate within one group
+type aggregation struct {
+	count   int
+	seen    map[string]bool
+	value   rdf.Term
+	sum     float64
+	integer bool // Whether all the values so far are integers
+	invalid bool // Whether one of the values wasn't a number
+}
+
+func (a *Aggregate) add(state *aggregation, term rdf.Term) {
+	if a.node != nil && term == nil {
+		return
+	} else if a.distinct && a.node != nil {
+		if state.seen[term.String()] {
+			return
+		}
+		state.seen[term.String()] = true
+	}
+
+	state.count++
+	switch a.op {
+	case "MIN":
+		if state.value == nil || order(term, state.value) < 0 {
+			state.value = term
+		}
+	case "MAX":
+		if state.value == nil || order(term, state.value) > 0 {
+			state.value = term
+		}
+	case "SUM", "AVG":
+		literal, is := term.(*rdf.Literal)
+		if !is || !numericTypes[getDatatype(literal).Value()] {
+			state.invalid = true
+			return
+		}
+		f, ok := parseNumber(literal.Value())
+		if !ok {
+			state.invalid = true
+			return
+		}
+		state.sum += f
+		state.integer = state.integer && integerTypes[getDatatype(literal).Value()]
+	}
+}
+
+func (a *Aggregate) result(state *aggregation) rdf.Term {
+	switch a.op {
+	case "COUNT":
+		return newInteger(int64(state.count))
+	case "MIN", "MAX":
+		return state.value
+	case "SUM":
+		if state.invalid {
+			return nil
+		} else if state.integer && math.Abs(state.sum) < 1<<53 {
+			return newInteger(int64(state.sum))
+		}
+		return rdf.NewLiteral(strconv.FormatFloat(state.sum, 'E', -1, 64), "", rdf.NewNamedNode(ld.XSDDouble))
+	case "AVG":
+		if state.invalid {
+			return nil
+		} else if state.count == 0 {
+			return newInteger(0)
+		} else if state.integer {
+			value := strconv.FormatFloat(state.sum/float64(state.count), 'f', -1, 64)
+			return rdf.NewLiteral(value, "", rdf.NewNamedNode(ld.XSDDecimal))
+		}
+		value := strconv.FormatFloat(state.sum/float64(state.count), 'E', -1, 64)
+		return rdf.NewLiteral(value, "", rdf.NewNamedNode(ld.XSDDouble))
+	}
+	return nil
+}
+
+var integerTypes = map[string]bool{
+	ld.XSDInteger:                   true,
+	ld.XSDNS + "int":                true,
+	ld.XSDNS + "long":               true,
+	ld.XSDNS + "short":              true,
+	ld.XSDNS + "byte":               true,
+	ld.XSDNS + "nonNegativeInteger": true,
+	ld.XSDNS + "nonPositiveInteger": true,
+	ld.XSDNS + "positiveInteger":    true,
+	ld.XSDNS + "negativeInteger":    true,
+	ld.XSDNS + "unsignedInt":        true,
+	ld.XSDNS + "unsignedLong":       true,
+	ld.XSDNS + "unsignedShort":      true,
+	ld.XSDNS + "unsignedByte":       true,
+}
+
+func newInteger(i int64) *rdf.Literal {
+	return rdf.NewLiteral(strconv.FormatInt(i, 10), "", rdf.NewNamedNode(ld.XSDInteger))
+}
+
+// order is a total order on terms: blank nodes, then IRIs, then literals,
+// which are compared by value when they can be and lexically otherwise.
+func order(a, b rdf.Term) int {
+	rank := map[string]int{rdf.BlankNodeType: 0, rdf.NamedNodeType: 1, rdf.LiteralType: 2}
+	if r, s := rank[a.TermType()], rank[b.TermType()]; r != s {
+		return r - s
+	}
+
+	if c, ok := compare(a, b); ok {
+		return c
+	} else if x, is := a.(*rdf.Literal); is {
+		y := b.(*rdf.Literal)
+		if c := strings.Compare(getDatatype(x).Value(), getDatatype(y).Value()); c != 0 {
+			return c
+		} else if c := strings.Compare(x.Language(), y.Language()); c != 0 {
+			return c
+		}
+	}
+	return strings.Compare(a.Value(), b.Value())
+}
+
+// Aggregate groups the rest of the iterator's solutions by the values of the
+// given variables, and returns one row for each group: the values of the group's
+// variables followed by the values of the aggregates. The groups are in the order
+// of their first solution. Without any groups, there is exactly one row.
+// When none of the aggregates depend on repeated values, the iterator
+// skips over the solutions that only differ in the later variables.
+func (iter *Iterator) Aggregate(groups []rdf.Term, aggregates ...*Aggregate) ([][]rdf.Term, error) {
+	if iter.empty {
+		if len(groups) > 0 {
+			return [][]rdf.Term{}, nil
+		}
+		return [][]rdf.Term{iter.aggregateRow(nil, aggregates, newStates(aggregates))}, nil
+	}
+
+	domain := iter.Domain()
+	positions := make(map[string]int, len(domain))
+	for i, node := range domain {
+		if node.TermType() == rdf.VariableType {
+			positions[node.String()] = i
+		}
+	}
+
+	// last is the last variable that the aggregates depend on,
+	// or -1 if they depend on every solution.
+	last := -1
+	for _, node := range groups {
+		i, has := positions[node.String()]
+		if !has {
+			return nil, ErrInvalidDomain
+		} else if i > last {
+			last = i
+		}
+	}
+
+	repeated := false
+	for _, a := range aggregates {
+		if a.node == nil {
+			repeated = true
+			continue
+		}
+
+		i, has := positions[a.node.String()]
+		if !has {
+			return nil, ErrInvalidDomain
+		} else if i > last {
+			last = i
+		}
+		repeated = repeated || !a.distinct
+	}
+
+	if iter.countable(groups, aggregates) {
+		return iter.aggregateCounts(groups, aggregates)
+	}
+
+	var node rdf.Term
+	if !repeated && last >= 0 {
+		node = domain[last]
+	}
+
+	keys := []string{}
+	rows := map[string][]rdf.Term{}
+	states := map[string][]*aggregation{}
+	if len(groups) == 0 {
+		keys = append(keys, "")
+		states[""] = newStates(aggregates)
+	}
+
+	for d, err := iter.Next(node); d != nil; d, err = iter.Next(node) {
+		if err != nil {
+			return nil, err
+		}
+
+		index := iter.Index()
+		row := make([]rdf.Term, len(groups))
+		values := make([]string, len(groups))
+		for i, node := range groups {
+			row[i] = index[positions[node.String()]]
+			if row[i] != nil {
+				values[i] = row[i].String()
+			}
+		}
+
+		key := strings.Join(values, "\t")
+		if _, has := states[key]; !has {
+			keys = append(keys, key)
+			rows[key] = row
+			states[key] = newStates(aggregates)
+		}
+
+		for i, a := range aggregates {
+			var term rdf.Term
+			if a.node != nil {
+				term = index[positions[a.node.String()]]
+			}
+			a.add(states[key][i], term)
+		}
+	}
+
+	result := make([][]rdf.Term, len(keys))
+	for i, key := range keys {
+		result[i] = iter.aggregateRow(rows[key], aggregates, states[key])
+	}
+	return result, nil
+}
+
+func newStates(aggregates []*Aggregate) []*aggregation {
+	states := make([]*aggregation, len(aggregates))
+	for i := range aggregates {
+		states[i] = &aggregation{seen: map[string]bool{}, integer: true}
+	}
+	return states
+}
+
+func (iter *Iterator) aggregateRow(row []rdf.Term, aggregates []*Aggregate, states []*aggregation) []rdf.Term {
+	result := make([]rdf.Term, len(row), len(row)+len(aggregates))
+	copy(result, row)
+	for i, a := range aggregates {
+		result = append(result, a.result(states[i]))
+	}
+	return result
+}
+
+// countable returns true if the aggregates are all counts of a query that's
+// grouped by every variable but the last one, and the last variable's count
+// is read from the unary and binary indices when the group's values are
+// pushed into its only constraint.
+func (iter *Iterator) countable(groups []rdf.Term, aggregates []*Aggregate) bool {
+	if iter.branches != nil || len(iter.optionals) > 0 || iter.limited || iter.partitions != nil {
+		return false
+	} else if iter.pivot != len(iter.variables) || len(groups) != len(iter.variables)-1 {
+		return false
+	} else if !iter.counts(len(groups)) {
+		return false
+	}
+
+	for i, node := range groups {
+		if !node.Equal(iter.domain[i]) {
+			return false
+		}
+	}
+
+	last := iter.domain[len(groups)]
+	for _, a := range aggregates {
+		if a.op != "COUNT" || a.distinct && !last.Equal(a.node) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (iter *Iterator) aggregateCounts(groups []rdf.Term, aggregates []*Aggregate) ([][]rdf.Term, error) {
+	if len(groups) == 0 {
+		count, err := iter.Count(nil)
+		if err != nil {
+			return nil, err
+		}
+
+		states := newStates(aggregates)
+		for _, state := range states {
+			state.count = count
+		}
+		return [][]rdf.Term{iter.aggregateRow(nil, aggregates, states)}, nil
+	}
+
+	node := groups[len(groups)-1]
+	result := [][]rdf.Term{}
+	for d, err := iter.Next(node); d != nil; d, err = iter.Next(node) {
+		if err != nil {
+			return nil, err
+		}
+
+		states := newStates(aggregates)
+		count := iter.countGroup(len(groups))
+		for _, state := range states {
+			state.count = count
+		}
+
+		index := iter.Index()
+		result = append(result, iter.aggregateRow(index[:len(groups)], aggregates, states))
+	}
+
+	return result, nil
+}
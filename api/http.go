@@ -52,10 +52,7 @@ func (api *httpAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 				w.Write([]byte{'\n'})
 			}
 		} else if contentType == jsonLdMime {
-			dataset := styx.ToRDFDataset(quads)
-			opts := ld.NewJsonLdOptions(node.Value())
-			opts.UseNativeTypes = true
-			result, err := ld.NewJsonLdApi().FromRDF(dataset, opts)
+			result, err := fromRDF(quads, node.Value())
 			if err != nil {
 				w.WriteHeader(500)
 				w.Write([]byte(err.Error()))
@@ -151,3 +148,11 @@ func (api *httpAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(405)
 	}
 }
+
+// fromRDF converts quads to a JSON-LD document with native types
+func fromRDF(quads []*rdf.Quad, base string) (interface{}, error) {
+	dataset := styx.ToRDFDataset(quads)
+	opts := ld.NewJsonLdOptions(base)
+	opts.UseNativeTypes = true
+	return ld.NewJsonLdApi().FromRDF(dataset, opts)
+}
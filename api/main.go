@@ -72,6 +72,8 @@ func main() {
 		Debug:          false,
 	}).Handler(api)
 
+	http.Handle("/sparql", newSPARQLHandler(store))
+
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		conns := strings.Split(r.Header.Get("Connection"), ", ")
 		for _, c := range conns {
@@ -43,15 +43,30 @@ func handleRPC(w http.ResponseWriter, r *http.Request, store *styx.Store) {
 type method func(params []json.RawMessage, store *styx.Store, handler *rpcHandler) (interface{}, int64, error)
 
 var methods = map[string]method{
-	"query": callQuery,
-	"next":  callNext,
-	"seek":  callSeek,
-	"prov":  callProv,
-	"close": callClose,
+	"query":       callQuery,
+	"next":        callNext,
+	"prev":        callPrev,
+	"seek":        callSeek,
+	"seekReverse": callSeekReverse,
+	"prov":        callProv,
+	"cursor":      callCursor,
+	"explain":     callExplain,
+	"close":       callClose,
+}
+
+// queryParams are the optional fourth parameter of the "query" method
+type queryParams struct {
+	Limit      *int            `json:"limit"`
+	Offset     int             `json:"offset"`
+	Parallel   int             `json:"parallel"`
+	Cursor     string          `json:"cursor"`
+	Projection json.RawMessage `json:"projection"`
+	Allow      []string        `json:"allow"`
+	Deny       []string        `json:"deny"`
 }
 
 func callQuery(params []json.RawMessage, store *styx.Store, handler *rpcHandler) (interface{}, int64, error) {
-	if len(params) == 0 || len(params) > 3 {
+	if len(params) == 0 || len(params) > 4 {
 		return nil, jsonrpc2.CodeInvalidParams, nil
 	}
 
@@ -77,7 +92,46 @@ func callQuery(params []json.RawMessage, store *styx.Store, handler *rpcHandler)
 		}
 	}
 
-	handler.iter, err = store.Query(quads, domain, index)
+	var options []styx.QueryOption
+	var offset int
+	if len(params) > 3 {
+		var p queryParams
+		err = json.Unmarshal(params[3], &p)
+		if err != nil || p.Offset < 0 || p.Limit != nil && *p.Limit < 0 || p.Allow != nil && p.Deny != nil {
+			return nil, jsonrpc2.CodeInvalidParams, err
+		}
+
+		if p.Limit != nil {
+			options = append(options, styx.WithLimit(*p.Limit))
+		}
+		if p.Parallel > 1 {
+			options = append(options, styx.WithParallel(p.Parallel))
+		}
+		if p.Cursor != "" {
+			options = append(options, styx.WithCursor(p.Cursor))
+		}
+		if p.Projection != nil {
+			var projection []rdf.Term
+			projection, err = rdf.UnmarshalTerms(p.Projection)
+			if err != nil {
+				return nil, jsonrpc2.CodeInvalidParams, err
+			}
+			options = append(options, styx.WithProjection(projection...))
+		}
+		if p.Allow != nil {
+			options = append(options, styx.WithSources(styx.AllowDatasets(p.Allow...)))
+		} else if p.Deny != nil {
+			options = append(options, styx.WithSources(styx.DenyDatasets(p.Deny...)))
+		}
+		offset = p.Offset
+	}
+
+	handler.iter, err = store.Query(quads, domain, index, options...)
+	if err != nil {
+		return nil, jsonrpc2.CodeInternalError, err
+	}
+
+	_, err = handler.iter.Skip(offset)
 	if err != nil {
 		return nil, jsonrpc2.CodeInternalError, err
 	}
@@ -100,6 +154,15 @@ func callClose(params []json.RawMessage, store *styx.Store, handler *rpcHandler)
 }
 
 func callNext(params []json.RawMessage, store *styx.Store, handler *rpcHandler) (interface{}, int64, error) {
+	return move(params, handler, handler.iter.Next)
+}
+
+func callPrev(params []json.RawMessage, store *styx.Store, handler *rpcHandler) (interface{}, int64, error) {
+	return move(params, handler, handler.iter.Prev)
+}
+
+// move calls Next or Prev on the handler's iterator with the optional node in params
+func move(params []json.RawMessage, handler *rpcHandler, f func(rdf.Term) ([]rdf.Term, error)) (interface{}, int64, error) {
 	if handler.iter == nil {
 		return nil, jsonrpc2.CodeInvalidRequest, nil
 	}
@@ -122,7 +185,7 @@ func callNext(params []json.RawMessage, store *styx.Store, handler *rpcHandler)
 		}
 	}
 
-	delta, err := handler.iter.Next(term)
+	delta, err := f(term)
 
 	if err != nil {
 		return nil, jsonrpc2.CodeInternalError, err
@@ -132,6 +195,15 @@ func callNext(params []json.RawMessage, store *styx.Store, handler *rpcHandler)
 }
 
 func callSeek(params []json.RawMessage, store *styx.Store, handler *rpcHandler) (interface{}, int64, error) {
+	return seek(params, handler, handler.iter.Seek)
+}
+
+func callSeekReverse(params []json.RawMessage, store *styx.Store, handler *rpcHandler) (interface{}, int64, error) {
+	return seek(params, handler, handler.iter.SeekReverse)
+}
+
+// seek calls Seek or SeekReverse on the handler's iterator with the optional index in params
+func seek(params []json.RawMessage, handler *rpcHandler, f func([]rdf.Term) error) (interface{}, int64, error) {
 	if handler.iter == nil {
 		return nil, jsonrpc2.CodeInvalidRequest, nil
 	}
@@ -149,7 +221,7 @@ func callSeek(params []json.RawMessage, store *styx.Store, handler *rpcHandler)
 		}
 	}
 
-	err = handler.iter.Seek(index)
+	err = f(index)
 	if err != nil {
 		return nil, jsonrpc2.CodeInternalError, err
 	}
@@ -167,13 +239,46 @@ func callProv(params []json.RawMessage, store *styx.Store, handler *rpcHandler)
 	}
 
 	prov, err := handler.iter.Prov()
-	if err != nil {
+	if err == styx.ErrEndOfSolutions {
+		return nil, 0, nil
+	} else if err != nil {
 		return nil, jsonrpc2.CodeInternalError, err
 	}
 
 	return prov, 0, nil
 }
 
+func callCursor(params []json.RawMessage, store *styx.Store, handler *rpcHandler) (interface{}, int64, error) {
+	if handler.iter == nil {
+		return nil, jsonrpc2.CodeInvalidRequest, nil
+	}
+
+	if len(params) > 0 {
+		return nil, jsonrpc2.CodeInvalidParams, nil
+	}
+
+	cursor, err := handler.iter.Cursor()
+	if err == styx.ErrEndOfSolutions {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, jsonrpc2.CodeInternalError, err
+	}
+
+	return cursor, 0, nil
+}
+
+func callExplain(params []json.RawMessage, store *styx.Store, handler *rpcHandler) (interface{}, int64, error) {
+	if handler.iter == nil {
+		return nil, jsonrpc2.CodeInvalidRequest, nil
+	}
+
+	if len(params) > 0 {
+		return nil, jsonrpc2.CodeInvalidParams, nil
+	}
+
+	return handler.iter.Explain(), 0, nil
+}
+
 type rpcHandler struct {
 	store *styx.Store
 	iter  *styx.Iterator
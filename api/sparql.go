@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	content "github.com/joeltg/negotiate/content"
+	ld "github.com/piprate/json-gold/ld"
+	cors "github.com/rs/cors"
+	rdf "github.com/underlay/go-rdfjs"
+	styx "github.com/underlay/styx"
+)
+
+type sparqlAPI struct {
+	store *styx.Store
+}
+
+// newSPARQLHandler returns the SPARQL endpoint with CORS headers for browsers
+func newSPARQLHandler(store *styx.Store) http.Handler {
+	return cors.New(cors.Options{
+		AllowCredentials: false,
+		AllowedMethods:   []string{http.MethodGet, http.MethodPost},
+		AllowedHeaders:   []string{"Content-Type", "Accept"},
+		ExposedHeaders:   []string{"Content-Type"},
+		Debug:            false,
+	}).Handler(&sparqlAPI{store: store})
+}
+
+var sparqlQueryMime = "application/sparql-query"
+var formMime = "application/x-www-form-urlencoded"
+var sparqlJSONMime = "application/sparql-results+json"
+var sparqlXMLMime = "application/sparql-results+xml"
+var csvMime = "text/csv"
+var tsvMime = "text/tab-separated-values"
+
+// maxQueryBytes is the largest request body that the endpoint reads,
+// which is the same as the limit that ParseForm uses by default
+const maxQueryBytes = 10 << 20
+
+// CSV and TSV results are only defined for SELECT queries
+var selectOffers = []string{sparqlJSONMime, jsonMime, sparqlXMLMime, csvMime, tsvMime}
+var askOffers = []string{sparqlJSONMime, jsonMime, sparqlXMLMime}
+var constructOffers = []string{nQuadsMime, jsonLdMime, jsonMime}
+
+func (api *sparqlAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var query string
+	if r.Method == http.MethodGet {
+		query = r.URL.Query().Get("query")
+	} else if r.Method == http.MethodPost {
+		if r.ContentLength > maxQueryBytes {
+			w.WriteHeader(413)
+			return
+		}
+
+		// Bodies without a length get cut off at the limit instead
+		r.Body = http.MaxBytesReader(w, r.Body, maxQueryBytes)
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if contentType == sparqlQueryMime {
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				w.WriteHeader(400)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			query = string(body)
+		} else if contentType == formMime {
+			err := r.ParseForm()
+			if err != nil {
+				w.WriteHeader(400)
+				w.Write([]byte(err.Error()))
+				return
+			}
+			query = r.PostForm.Get("query")
+		} else {
+			w.WriteHeader(415)
+			return
+		}
+	} else {
+		w.WriteHeader(405)
+		return
+	}
+
+	if query == "" {
+		w.WriteHeader(400)
+		w.Write([]byte("Missing query"))
+		return
+	}
+
+	q, err := styx.ParseSPARQL(query)
+	if err != nil {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	iter, err := api.store.Query(q.Pattern, q.Domain, nil, q.Options...)
+	if err == styx.ErrInvalidFilter || err == styx.ErrInvalidDomain {
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	} else if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	defer iter.Close()
+
+	if q.Template != nil {
+		construct(w, r, q, iter)
+		return
+	}
+
+	rows, err := q.Results(iter)
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	offers := selectOffers
+	if q.Ask {
+		offers = askOffers
+	}
+
+	contentType := content.NegotiateContentType(r, offers, sparqlJSONMime)
+	w.Header().Add("Content-Type", contentType)
+	w.WriteHeader(200)
+	switch contentType {
+	case sparqlJSONMime, jsonMime:
+		err = writeSPARQLJSON(w, q, rows)
+	case sparqlXMLMime:
+		err = writeSPARQLXML(w, q, rows)
+	case csvMime:
+		err = writeSPARQLCSV(w, q, rows)
+	case tsvMime:
+		err = writeSPARQLTSV(w, q, rows)
+	}
+
+	// The status has already been sent, so all we can do is log the error
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// construct writes the results of a CONSTRUCT query as N-Quads, which are
+// streamed as the solutions are found, or as JSON-LD or JSON quads
+func construct(w http.ResponseWriter, r *http.Request, q *styx.SPARQLQuery, iter *styx.Iterator) {
+	contentType := content.NegotiateContentType(r, constructOffers, nQuadsMime)
+	if contentType == nQuadsMime {
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(200)
+		err := q.Construct(iter, func(quad *rdf.Quad) error {
+			_, err := io.WriteString(w, quad.String()+"\n")
+			return err
+		})
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	}
+
+	quads := []*rdf.Quad{}
+	err := q.Construct(iter, func(quad *rdf.Quad) error {
+		quads = append(quads, quad)
+		return nil
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	if contentType == jsonLdMime {
+		result, err := fromRDF(quads, "")
+		if err != nil {
+			w.WriteHeader(500)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(200)
+		if err := json.NewEncoder(w).Encode(result); err != nil {
+			log.Println(err)
+		}
+	} else if contentType == jsonMime {
+		w.Header().Add("Content-Type", contentType)
+		w.WriteHeader(200)
+		if err := json.NewEncoder(w).Encode(quads); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func writeSPARQLJSON(w io.Writer, q *styx.SPARQLQuery, rows [][]rdf.Term) error {
+	if q.Ask {
+		return json.NewEncoder(w).Encode(map[string]interface{}{
+			"head":    map[string]interface{}{},
+			"boolean": len(rows) > 0,
+		})
+	}
+
+	vars := make([]string, len(q.Variables))
+	for i, node := range q.Variables {
+		vars[i] = node.Value()
+	}
+
+	bindings := make([]map[string]map[string]string, len(rows))
+	for i, row := range rows {
+		bindings[i] = map[string]map[string]string{}
+		for j, term := range row {
+			switch term := term.(type) {
+			case *rdf.NamedNode:
+				bindings[i][vars[j]] = map[string]string{"type": "uri", "value": term.Value()}
+			case *rdf.BlankNode:
+				bindings[i][vars[j]] = map[string]string{"type": "bnode", "value": term.Value()}
+			case *rdf.Literal:
+				binding := map[string]string{"type": "literal", "value": term.Value()}
+				if language := term.Language(); language != "" {
+					binding["xml:lang"] = language
+				} else if datatype := term.Datatype().Value(); datatype != ld.XSDString {
+					binding["datatype"] = datatype
+				}
+				bindings[i][vars[j]] = binding
+			}
+		}
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"head":    map[string]interface{}{"vars": vars},
+		"results": map[string]interface{}{"bindings": bindings},
+	})
+}
+
+func escapeXML(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func writeSPARQLXML(w io.Writer, q *styx.SPARQLQuery, rows [][]rdf.Term) (err error) {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<sparql xmlns="http://www.w3.org/2005/sparql-results#">` + "\n")
+	b.WriteString("<head>\n")
+	for _, node := range q.Variables {
+		fmt.Fprintf(&b, "  <variable name=\"%s\"/>\n", escapeXML(node.Value()))
+	}
+	b.WriteString("</head>\n")
+
+	if q.Ask {
+		fmt.Fprintf(&b, "<boolean>%t</boolean>\n", len(rows) > 0)
+	} else {
+		b.WriteString("<results>\n")
+		for _, row := range rows {
+			b.WriteString("  <result>\n")
+			for j, term := range row {
+				if term == nil {
+					continue
+				}
+
+				fmt.Fprintf(&b, "    <binding name=\"%s\">", escapeXML(q.Variables[j].Value()))
+				switch term := term.(type) {
+				case *rdf.NamedNode:
+					fmt.Fprintf(&b, "<uri>%s</uri>", escapeXML(term.Value()))
+				case *rdf.BlankNode:
+					fmt.Fprintf(&b, "<bnode>%s</bnode>", escapeXML(term.Value()))
+				case *rdf.Literal:
+					if language := term.Language(); language != "" {
+						fmt.Fprintf(&b, "<literal xml:lang=\"%s\">", escapeXML(language))
+					} else if datatype := term.Datatype().Value(); datatype != ld.XSDString {
+						fmt.Fprintf(&b, "<literal datatype=\"%s\">", escapeXML(datatype))
+					} else {
+						b.WriteString("<literal>")
+					}
+					b.WriteString(escapeXML(term.Value()))
+					b.WriteString("</literal>")
+				}
+				b.WriteString("</binding>\n")
+			}
+			b.WriteString("  </result>\n")
+		}
+		b.WriteString("</results>\n")
+	}
+
+	b.WriteString("</sparql>\n")
+	_, err = io.WriteString(w, b.String())
+	return
+}
+
+// writeSPARQLCSV writes the lexical forms of the values, without
+// the datatypes and language tags of literals
+func writeSPARQLCSV(w io.Writer, q *styx.SPARQLQuery, rows [][]rdf.Term) error {
+	writer := csv.NewWriter(w)
+	writer.UseCRLF = true
+
+	record := make([]string, len(q.Variables))
+	for i, node := range q.Variables {
+		record[i] = node.Value()
+	}
+	if err := writer.Write(record); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for i, term := range row {
+			switch term := term.(type) {
+			case nil:
+				record[i] = ""
+			case *rdf.BlankNode:
+				record[i] = term.String()
+			default:
+				record[i] = term.Value()
+			}
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeSPARQLTSV writes the values in the same syntax as N-Quads
+func writeSPARQLTSV(w io.Writer, q *styx.SPARQLQuery, rows [][]rdf.Term) error {
+	record := make([]string, len(q.Variables))
+	for i, node := range q.Variables {
+		record[i] = node.String()
+	}
+	if _, err := io.WriteString(w, strings.Join(record, "\t")+"\n"); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		for i, term := range row {
+			if term == nil {
+				record[i] = ""
+			} else {
+				record[i] = term.String()
+			}
+		}
+		if _, err := io.WriteString(w, strings.Join(record, "\t")+"\n"); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	rdf "github.com/underlay/go-rdfjs"
+	styx "github.com/underlay/styx"
+)
+
+const selectQuery = `PREFIX schema: <http://schema.org/>
+SELECT ?person ?name WHERE { ?person a schema:Person ; schema:name ?name }`
+
+const constructQuery = `PREFIX schema: <http://schema.org/>
+CONSTRUCT { ?person schema:alternateName ?name } WHERE { ?person schema:name ?name }`
+
+var schemaPerson = rdf.NewNamedNode("http://schema.org/Person")
+var schemaName = rdf.NewNamedNode("http://schema.org/name")
+var rdfType = rdf.NewNamedNode("http://www.w3.org/1999/02/22-rdf-syntax-ns#type")
+
+func openSPARQL(t *testing.T) (*styx.Store, http.Handler) {
+	store, err := styx.NewMemoryStore(&styx.Config{
+		TagScheme: styx.NewPrefixTagScheme("http://example.com/"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jane := rdf.NewNamedNode("http://people.com/jane")
+	john := rdf.NewNamedNode("http://people.com/john")
+	err = store.Set(rdf.NewNamedNode("http://example.com/people"), []*rdf.Quad{
+		rdf.NewQuad(jane, rdfType, schemaPerson, rdf.Default),
+		rdf.NewQuad(jane, schemaName, rdf.NewLiteral("Jane Doe", "", nil), rdf.Default),
+		rdf.NewQuad(john, rdfType, schemaPerson, rdf.Default),
+		rdf.NewQuad(john, schemaName, rdf.NewLiteral("John Doe", "en", rdf.RDFLangString), rdf.Default),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return store, newSPARQLHandler(store)
+}
+
+func serve(handler http.Handler, r *http.Request) (*http.Response, string) {
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	res := w.Result()
+	body, _ := ioutil.ReadAll(res.Body)
+	return res, string(body)
+}
+
+func getQuery(query, accept string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/sparql?query="+url.QueryEscape(query), nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	return r
+}
+
+var selectJSON = map[string]interface{}{
+	"head": map[string]interface{}{"vars": []interface{}{"person", "name"}},
+	"results": map[string]interface{}{
+		"bindings": []interface{}{
+			map[string]interface{}{
+				"person": map[string]interface{}{"type": "uri", "value": "http://people.com/jane"},
+				"name":   map[string]interface{}{"type": "literal", "value": "Jane Doe"},
+			},
+			map[string]interface{}{
+				"person": map[string]interface{}{"type": "uri", "value": "http://people.com/john"},
+				"name":   map[string]interface{}{"type": "literal", "value": "John Doe", "xml:lang": "en"},
+			},
+		},
+	},
+}
+
+func TestSPARQLMethods(t *testing.T) {
+	store, handler := openSPARQL(t)
+	defer store.Close()
+
+	post := httptest.NewRequest(http.MethodPost, "/sparql", strings.NewReader(selectQuery))
+	post.Header.Set("Content-Type", sparqlQueryMime+"; charset=utf-8")
+
+	form := url.Values{"query": {selectQuery}}
+	postForm := httptest.NewRequest(http.MethodPost, "/sparql", strings.NewReader(form.Encode()))
+	postForm.Header.Set("Content-Type", formMime)
+
+	requests := map[string]*http.Request{
+		"GET":       getQuery(selectQuery, ""),
+		"POST":      post,
+		"POST form": postForm,
+	}
+
+	for name, r := range requests {
+		res, body := serve(handler, r)
+		if res.StatusCode != 200 {
+			t.Errorf("%s: unexpected status %d: %s", name, res.StatusCode, body)
+			continue
+		} else if contentType := res.Header.Get("Content-Type"); contentType != sparqlJSONMime {
+			t.Errorf("%s: unexpected content type %s", name, contentType)
+		}
+
+		var result map[string]interface{}
+		if err := json.Unmarshal([]byte(body), &result); err != nil {
+			t.Errorf("%s: %s", name, err)
+		} else if !reflect.DeepEqual(result, selectJSON) {
+			t.Errorf("%s: unexpected results %s", name, body)
+		}
+	}
+
+	res, _ := serve(handler, httptest.NewRequest(http.MethodPut, "/sparql", strings.NewReader(selectQuery)))
+	if res.StatusCode != 405 {
+		t.Errorf("Expected PUT to return 405, got %d", res.StatusCode)
+	}
+
+	// Bodies over the limit are refused, and bodies
+	// without a length are cut off when they reach it
+	large := selectQuery + strings.Repeat(" ", maxQueryBytes)
+	for length, status := range map[int64]int{int64(len(large)): 413, -1: 400} {
+		r := httptest.NewRequest(http.MethodPost, "/sparql", strings.NewReader(large))
+		r.Header.Set("Content-Type", sparqlQueryMime)
+		r.ContentLength = length
+		res, _ = serve(handler, r)
+		if res.StatusCode != status {
+			t.Errorf("Expected a large POST with length %d to return %d, got %d", length, status, res.StatusCode)
+		}
+	}
+
+	plain := httptest.NewRequest(http.MethodPost, "/sparql", strings.NewReader(selectQuery))
+	plain.Header.Set("Content-Type", "text/plain")
+	res, _ = serve(handler, plain)
+	if res.StatusCode != 415 {
+		t.Errorf("Expected text/plain to return 415, got %d", res.StatusCode)
+	}
+}
+
+func TestSPARQLContentTypes(t *testing.T) {
+	store, handler := openSPARQL(t)
+	defer store.Close()
+
+	// Accept header => expected body
+	selects := map[string]string{
+		csvMime: "person,name\r\n" +
+			"http://people.com/jane,Jane Doe\r\n" +
+			"http://people.com/john,John Doe\r\n",
+		tsvMime: "?person\t?name\n" +
+			"<http://people.com/jane>\t\"Jane Doe\"\n" +
+			"<http://people.com/john>\t\"John Doe\"@en\n",
+		sparqlXMLMime: `<?xml version="1.0" encoding="UTF-8"?>
+<sparql xmlns="http://www.w3.org/2005/sparql-results#">
+<head>
+  <variable name="person"/>
+  <variable name="name"/>
+</head>
+<results>
+  <result>
+    <binding name="person"><uri>http://people.com/jane</uri></binding>
+    <binding name="name"><literal>Jane Doe</literal></binding>
+  </result>
+  <result>
+    <binding name="person"><uri>http://people.com/john</uri></binding>
+    <binding name="name"><literal xml:lang="en">John Doe</literal></binding>
+  </result>
+</results>
+</sparql>
+`,
+	}
+
+	for accept, expected := range selects {
+		res, body := serve(handler, getQuery(selectQuery, accept))
+		if res.StatusCode != 200 {
+			t.Errorf("%s: unexpected status %d: %s", accept, res.StatusCode, body)
+		} else if contentType := res.Header.Get("Content-Type"); contentType != accept {
+			t.Errorf("%s: unexpected content type %s", accept, contentType)
+		} else if body != expected {
+			t.Errorf("%s: unexpected body\n%s", accept, body)
+		}
+	}
+
+	res, body := serve(handler, getQuery(selectQuery, jsonMime))
+	var result map[string]interface{}
+	if contentType := res.Header.Get("Content-Type"); contentType != jsonMime {
+		t.Errorf("%s: unexpected content type %s", jsonMime, contentType)
+	} else if err := json.Unmarshal([]byte(body), &result); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(result, selectJSON) {
+		t.Errorf("%s: unexpected results %s", jsonMime, body)
+	}
+
+	// CSV and TSV aren't offered for ASK queries
+	ask := `ASK { <http://people.com/jane> <http://schema.org/name> "Jane Doe" }`
+	res, body = serve(handler, getQuery(ask, csvMime+", "+sparqlXMLMime+";q=0.5"))
+	if contentType := res.Header.Get("Content-Type"); contentType != sparqlXMLMime {
+		t.Errorf("ASK: unexpected content type %s", contentType)
+	} else if !strings.Contains(body, "<boolean>true</boolean>") {
+		t.Errorf("ASK: unexpected body\n%s", body)
+	}
+
+	res, body = serve(handler, getQuery(constructQuery, ""))
+	expected := `<http://people.com/jane> <http://schema.org/alternateName> "Jane Doe" .
+<http://people.com/john> <http://schema.org/alternateName> "John Doe"@en .
+`
+	if contentType := res.Header.Get("Content-Type"); contentType != nQuadsMime {
+		t.Errorf("CONSTRUCT: unexpected content type %s", contentType)
+	} else if body != expected {
+		t.Errorf("CONSTRUCT: unexpected body\n%s", body)
+	}
+
+	res, body = serve(handler, getQuery(constructQuery, jsonLdMime))
+	var document []interface{}
+	expectedDocument := []interface{}{
+		map[string]interface{}{
+			"@id": "http://people.com/jane",
+			"http://schema.org/alternateName": []interface{}{
+				map[string]interface{}{"@value": "Jane Doe"},
+			},
+		},
+		map[string]interface{}{
+			"@id": "http://people.com/john",
+			"http://schema.org/alternateName": []interface{}{
+				map[string]interface{}{"@value": "John Doe", "@language": "en"},
+			},
+		},
+	}
+	if contentType := res.Header.Get("Content-Type"); contentType != jsonLdMime {
+		t.Errorf("CONSTRUCT: unexpected content type %s", contentType)
+	} else if err := json.Unmarshal([]byte(body), &document); err != nil {
+		t.Error(err)
+	} else if !reflect.DeepEqual(document, expectedDocument) {
+		t.Errorf("CONSTRUCT: unexpected JSON-LD %s", body)
+	}
+}
+
+func TestSPARQLBadQuery(t *testing.T) {
+	store, handler := openSPARQL(t)
+	defer store.Close()
+
+	queries := map[string]string{
+		"missing query":  "",
+		"syntax error":   "SELECT ?s WHERE { ?s ?p",
+		"invalid filter": `SELECT ?s WHERE { ?s ?p ?o FILTER(?x = 1) }`,
+	}
+
+	for name, query := range queries {
+		res, body := serve(handler, getQuery(query, ""))
+		if res.StatusCode != 400 {
+			t.Errorf("%s: expected 400, got %d: %s", name, res.StatusCode, body)
+		} else if body == "" {
+			t.Errorf("%s: expected an error message", name)
+		}
+	}
+}
+
+func TestSPARQLCORS(t *testing.T) {
+	store, handler := openSPARQL(t)
+	defer store.Close()
+
+	r := getQuery(selectQuery, "")
+	r.Header.Set("Origin", "http://example.org")
+	res, _ := serve(handler, r)
+	if res.StatusCode != 200 {
+		t.Errorf("Unexpected status %d", res.StatusCode)
+	} else if origin := res.Header.Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("Unexpected Access-Control-Allow-Origin %q", origin)
+	} else if exposed := res.Header.Get("Access-Control-Expose-Headers"); exposed != "Content-Type" {
+		t.Errorf("Unexpected Access-Control-Expose-Headers %q", exposed)
+	}
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/sparql", nil)
+	preflight.Header.Set("Origin", "http://example.org")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	preflight.Header.Set("Access-Control-Request-Headers", "Content-Type")
+	res, _ = serve(handler, preflight)
+	if res.StatusCode/100 != 2 {
+		t.Errorf("Unexpected preflight status %d", res.StatusCode)
+	} else if origin := res.Header.Get("Access-Control-Allow-Origin"); origin != "*" {
+		t.Errorf("Unexpected preflight Access-Control-Allow-Origin %q", origin)
+	} else if methods := res.Header.Get("Access-Control-Allow-Methods"); methods != http.MethodPost {
+		t.Errorf("Unexpected Access-Control-Allow-Methods %q", methods)
+	}
+
+	preflight = httptest.NewRequest(http.MethodOptions, "/sparql", nil)
+	preflight.Header.Set("Origin", "http://example.org")
+	preflight.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+	res, _ = serve(handler, preflight)
+	if origin := res.Header.Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("Expected DELETE preflight to be refused, got %q", origin)
+	}
+}
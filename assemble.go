@@ -13,11 +13,17 @@ func newIterator(
 	query []*rdf.Quad,
 	domain []rdf.Term,
 	index []rdf.Term,
+	options *queryOptions,
 	tag TagScheme,
-	txn *badger.Txn,
+	planner Planner,
+	txn view,
 	dictionary Dictionary,
 ) (iter *Iterator, err error) {
 
+	if len(options.unions) > 0 {
+		return newUnion(query, domain, index, options, tag, planner, txn, dictionary)
+	}
+
 	if domain == nil {
 		domain = make([]rdf.Term, 0)
 	}
@@ -32,19 +38,30 @@ func newIterator(
 		unary:      newUnaryCache(),
 		binary:     newBinaryCache(),
 		tag:        tag,
+		planner:    planner,
 		txn:        txn,
 		dictionary: dictionary,
 	}
 
+	err = iter.project(options)
+	if err != nil {
+		return
+	}
+
+	iter.sources = newSources(options.source, dictionary)
+
+	iter.statistics, err = getStatistics(txn)
+	if err != nil {
+		return
+	}
+
 	var split bool
 	for i, node := range domain {
-		if node.TermType() == rdf.VariableType {
-			if split {
-				return nil, ErrInvalidDomain
-			}
-		} else if node.TermType() == rdf.BlankNodeType {
+		if t := node.TermType(); t != rdf.VariableType && t != rdf.BlankNodeType {
+			return nil, ErrInvalidDomain
+		} else if iter.existential(node) {
 			split = true
-		} else {
+		} else if split {
 			return nil, ErrInvalidDomain
 		}
 
@@ -60,15 +77,35 @@ func newIterator(
 	}
 
 	for i, quad := range query {
-		if quad.Graph().TermType() != rdf.DefaultGraphType {
-			continue
+		// The graph term is either the default graph, which matches
+		// triples in any graph, or a constant, or a blank node.
+		var graph ID
+		var g *variable
+		switch quad[3].TermType() {
+		case rdf.DefaultGraphType:
+		case rdf.NamedNodeType:
+			graph, err = dictionary.GetID(quad[3], rdf.Default)
+			if err != nil {
+				return
+			}
+		case rdf.VariableType, rdf.BlankNodeType:
+			g = iter.parseNode(quad[3])
+		default:
+			return iter, fmt.Errorf("Invalid graph term: %d", i)
 		}
 
 		variables := [3]*variable{}
 		for p := 0; p < 3; p++ {
 			variables[p] = iter.parseNode(quad[p])
+			if g != nil && variables[p] == g {
+				return iter, fmt.Errorf("Cannot handle repeated blank nodes in graph: %d", i)
+			}
 		}
 
+		// The neighbors of a triple's constraints are indexed by place,
+		// with the graph constraint (if there is one) at index 3.
+		neighbors := make([]*constraint, 4)
+
 		degree := 0
 		terms := [3]ID{}
 		for p := 0; p < 3; p++ {
@@ -83,13 +120,16 @@ func newIterator(
 		}
 
 		if degree == 0 {
-			iter.constants = append(iter.constants, &constraint{index: i, quad: quad})
+			iter.constants = append(iter.constants, &constraint{index: i, quad: quad, sources: iter.sources})
 		} else if degree == 1 {
 			// Only one of the terms is a blank node, so this is a first-degree constraint.
 			c := &constraint{
-				index: i,
-				quad:  quad,
-				terms: terms,
+				index:   i,
+				quad:    quad,
+				terms:   terms,
+				graph:   graph,
+				txn:     txn,
+				sources: iter.sources,
 			}
 
 			for ; c.place < 3; c.place++ {
@@ -98,6 +138,11 @@ func newIterator(
 				}
 			}
 
+			if g != nil {
+				c.neighbors = neighbors
+				neighbors[c.place] = c
+			}
+
 			err = iter.insertD1(variables[c.place], c, txn)
 			if err == ErrEndOfSolutions {
 				iter.empty = true
@@ -118,11 +163,17 @@ func newIterator(
 
 			q, r := (p+1)%3, (p+2)%3
 			if variables[q] == variables[r] {
+				if graph != NIL || g != nil {
+					return iter, fmt.Errorf("Cannot handle repeated blank nodes in a named graph: %d", i)
+				}
+
 				c := &constraint{
-					index: i,
-					place: q,
-					quad:  quad,
-					terms: terms,
+					index:   i,
+					place:   q,
+					quad:    quad,
+					terms:   terms,
+					txn:     txn,
+					sources: iter.sources,
 				}
 				err = iter.insertDZ(variables[q], c, txn)
 				if err == ErrEndOfSolutions {
@@ -132,9 +183,8 @@ func newIterator(
 					return
 				}
 			} else {
-				neighbors := make([]*constraint, 3)
-				a := &constraint{index: i, place: q, quad: quad, terms: terms, neighbors: neighbors}
-				b := &constraint{index: i, place: r, quad: quad, terms: terms, neighbors: neighbors}
+				a := &constraint{index: i, place: q, quad: quad, terms: terms, graph: graph, txn: txn, neighbors: neighbors, sources: iter.sources}
+				b := &constraint{index: i, place: r, quad: quad, terms: terms, graph: graph, txn: txn, neighbors: neighbors, sources: iter.sources}
 				neighbors[r], neighbors[q] = b, a
 
 				err = iter.insertD2(variables[q], variables[r], a, txn)
@@ -154,7 +204,150 @@ func newIterator(
 				}
 			}
 		} else if degree == 3 {
-			return nil, fmt.Errorf("Cannot handle all-blank triple: %d", i)
+			// All three terms are blank nodes.
+			// We insert a third-degree constraint for each different blank node,
+			// which starts out ranging over the unary index, and then gets
+			// narrowed to the binary and ternary indices as the other
+			// variables in the triple get assigned values.
+			// A repeated blank node gets one constraint at the first of its
+			// places, which checks the next place with .reflexive().
+			for p := Permutation(0); p < 3; p++ {
+				neighbors[p] = &constraint{index: i, place: p, quad: quad, graph: graph, txn: txn, neighbors: neighbors, sources: iter.sources}
+			}
+
+			if variables[0] == variables[1] && variables[1] == variables[2] {
+				neighbors[1], neighbors[2] = neighbors[0], neighbors[0]
+			} else {
+				for p := Permutation(0); p < 3; p++ {
+					if q := (p + 1) % 3; variables[p] == variables[q] {
+						neighbors[q] = neighbors[p]
+					}
+				}
+			}
+
+			repeated := neighbors[0] == neighbors[1] || neighbors[1] == neighbors[2] || neighbors[2] == neighbors[0]
+			if repeated && (graph != NIL || g != nil) {
+				return iter, fmt.Errorf("Cannot handle repeated blank nodes in a named graph: %d", i)
+			}
+
+			for p := Permutation(0); p < 3; p++ {
+				if neighbors[p].place != p {
+					continue
+				}
+
+				u, v, w := variables[p], variables[(p+1)%3], variables[(p+2)%3]
+				err = iter.insertD3(u, v, w, neighbors[p], txn)
+				if err == ErrEndOfSolutions {
+					iter.empty = true
+					return iter, nil
+				} else if err != nil {
+					return
+				}
+			}
+		}
+
+		if g != nil {
+			// The graph is a blank node, so we insert a graph constraint,
+			// which is connected to every constraint of the triple.
+			neighbors[3] = &constraint{index: i, place: 3, quad: quad, terms: terms, txn: txn, neighbors: neighbors, sources: iter.sources}
+			err = iter.insertGraph(g, variables, neighbors[3], txn)
+			if err == ErrEndOfSolutions {
+				iter.empty = true
+				return iter, nil
+			} else if err != nil {
+				return
+			}
+		}
+	}
+
+	// Ground triples don't constrain any variables, so they're checked right away.
+	// A query of only ground triples has a single empty solution if they're all there.
+	ok, err := iter.verify()
+	if err != nil {
+		return
+	} else if !ok {
+		iter.empty = true
+		return iter, nil
+	}
+
+	for i, path := range options.paths {
+		err = iter.parsePath(i, path, txn)
+		if err == ErrEndOfSolutions {
+			iter.empty = true
+			return iter, nil
+		} else if err != nil {
+			return
+		}
+	}
+
+	// Negations are filters on the variables that they share with the query
+	filters := options.filters
+	if len(options.negations) > 0 {
+		filters = make([]*Filter, len(options.filters), len(options.filters)+len(options.negations))
+		copy(filters, options.filters)
+		for _, n := range options.negations {
+			var filter *Filter
+			filter, err = iter.negate(n)
+			if err != nil {
+				return
+			} else if filter != nil {
+				filters = append(filters, filter)
+			}
+		}
+	}
+
+	// Filters that test more than one node make those nodes depend on
+	// each other, even if they aren't connected by any constraints.
+	for _, filter := range filters {
+		if len(filter.terms) == 0 {
+			// Filters over constants can be evaluated right away
+			if result, _ := filter.test(nil); !result {
+				iter.empty = true
+				return iter, nil
+			}
+			continue
+		}
+
+		indices := make([]int, 0, len(filter.terms))
+		for _, term := range filter.terms {
+			j, has := iter.ids[term.String()]
+			if !has {
+				return iter, ErrInvalidFilter
+			}
+			indices = append(indices, j)
+		}
+
+		for _, j := range indices {
+			u := iter.variables[j]
+			for _, k := range indices {
+				if j == k {
+					continue
+				} else if u.edges == nil {
+					u.edges = constraintMap{k: constraintSet{}}
+				} else if _, has := u.edges[k]; !has {
+					u.edges[k] = constraintSet{}
+				}
+			}
+		}
+	}
+
+	// Comparisons with ordered literals narrow the range of their node's values
+	if ranges, is := dictionary.(rangeDictionary); is {
+		for _, filter := range options.filters {
+			for _, b := range filter.bounds {
+				lower, upper, ok := ranges.getRange(b.op, b.value)
+				if !ok {
+					continue
+				}
+
+				u := iter.variables[iter.ids[b.node.String()]]
+				if lower > u.lower {
+					u.lower = lower
+				}
+				if u.upper == NIL || upper < u.upper {
+					u.upper = upper
+				}
+			}
 		}
 	}
 
@@ -179,8 +372,8 @@ func newIterator(
 
 		u.Sort()
 
-		u.root = u.cs.Seek(NIL)
-		if u.root == NIL {
+		u.root = u.cs.Seek(u.lower)
+		if u.root == NIL || (u.upper != NIL && u.root >= u.upper) {
 			err = ErrEmptyInterset
 			return
 		}
@@ -191,9 +384,13 @@ func newIterator(
 		u.value = u.root
 	}
 
-	// Sorting keeps variables at indices less than iter.pivot in place
-	if len(domain) < len(iter.domain)+1 {
-		sort.Stable(iter)
+	// Planning keeps variables at indices less than iter.pivot in place
+	if len(domain) < len(iter.domain) {
+		err = iter.reorder()
+		if err != nil {
+			return
+		}
+
 		// Now we're in a tricky spot. iter.domain and iter.variables
 		// have changed, but not iter.ids or the variable constraint maps.
 		transformation := make([]int, len(iter.domain))
@@ -222,8 +419,8 @@ func newIterator(
 	// Reset iter.pivot
 	iter.pivot = len(iter.domain)
 	for i, u := range iter.variables {
-		// Set iter.pivot to be the index of the first blank node
-		if i < iter.pivot && u.node.TermType() == rdf.BlankNodeType {
+		// Set iter.pivot to be the index of the first blank node or hidden variable
+		if i < iter.pivot && iter.existential(u.node) {
 			iter.pivot = i
 		}
 
@@ -234,13 +431,19 @@ func newIterator(
 				// These constraints are the ones that get pushed into,
 				// and so they get deleted from the D2 map
 				// (which is just for outgoing connections)
-				cs.Close()
 				for _, c := range cs {
+					if c.place == 3 || c.free() || c.path != nil {
+						// Graph, path, and third-degree constraints already
+						// have iterators or values for every prefix they use.
+						continue
+					} else if iter.variables[j].node.Equal(c.quad[3]) {
+						// Graph variables don't change the prefix
+						// of the constraints that they get pushed into.
+						continue
+					}
+					c.Close()
 					p := TernaryPrefixes[(c.place+1)%3]
-					c.iterator = txn.NewIterator(badger.IteratorOptions{
-						PrefetchValues: false,
-						Prefix:         []byte{p},
-					})
+					c.open(txn, []byte{p})
 				}
 				delete(u.edges, j)
 			}
@@ -290,6 +493,50 @@ func newIterator(
 		sort.Ints(iter.out[i])
 	}
 
+	// Attach each filter to the last of its nodes in the domain
+	for _, filter := range filters {
+		if len(filter.terms) == 0 {
+			continue
+		}
+
+		last := 0
+		for _, term := range filter.terms {
+			if j := iter.ids[term.String()]; j > last {
+				last = j
+			}
+		}
+		u := iter.variables[last]
+		u.filters = append(u.filters, filter)
+		if u.filter == nil {
+			u.filter = iter.filter(u)
+		}
+	}
+
+	// The variables that only occur in optional groups come after the rest of the domain
+	optionalIds := map[string]bool{}
+	for _, group := range options.optionals {
+		iter.optionals = append(iter.optionals, &optional{optionalPattern: group})
+		terms := make([]rdf.Term, 0, 4*len(group.pattern)+2*len(group.options.paths))
+		for _, quad := range group.pattern {
+			terms = append(terms, quad[:]...)
+		}
+		for _, path := range group.options.paths {
+			terms = append(terms, path.subject, path.object)
+		}
+		for _, term := range terms {
+			value := term.String()
+			if term.TermType() != rdf.VariableType || optionalIds[value] {
+				continue
+			} else if _, has := iter.ids[value]; has {
+				continue
+			}
+			optionalIds[value] = true
+			iter.optionalDomain = append(iter.optionalDomain, term)
+		}
+	}
+
+	iter.plan = iter.explain()
+
 	l := len(iter.domain)
 	iter.cache = make([]*vcache, l)
 	iter.blacklist = make([]bool, l)
@@ -298,6 +545,50 @@ func newIterator(
 	return iter, iter.Seek(index)
 }
 
+// verify checks that the triples in the query without any variables
+// or blank nodes are in the database, and in the query's datasets.
+func (iter *Iterator) verify() (bool, error) {
+	for _, c := range iter.constants {
+		terms := [3]ID{}
+		for p := 0; p < 3; p++ {
+			id, err := iter.dictionary.GetID(c.quad[p], rdf.Default)
+			if err == ErrNotFound {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+			terms[p] = id
+		}
+
+		var graph ID
+		if c.quad[3].TermType() == rdf.NamedNodeType {
+			id, err := iter.dictionary.GetID(c.quad[3], rdf.Default)
+			if err == ErrNotFound {
+				return false, nil
+			} else if err != nil {
+				return false, err
+			}
+			graph = id
+		}
+
+		if graph != NIL || c.sources != nil {
+			c.terms, c.graph, c.txn = terms, graph, iter.txn
+			if !c.asserted(terms[c.place]) {
+				return false, nil
+			}
+			continue
+		}
+
+		_, err := iter.txn.Get(assembleKey(TernaryPrefixes[0], false, terms[:]...))
+		if err == badger.ErrKeyNotFound {
+			return false, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 func (iter *Iterator) parseNode(node rdf.Term) *variable {
 	if node.TermType() != rdf.VariableType && node.TermType() != rdf.BlankNodeType {
 		return nil
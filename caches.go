@@ -15,7 +15,7 @@ func newUnaryCache() unaryCache {
 }
 
 // getUnaryIndex returns the 6-tuple of counts from an item
-func getUnaryIndex(item *badger.Item) (*[6]uint32, error) {
+func getUnaryIndex(item viewItem) (*[6]uint32, error) {
 	result := &[6]uint32{}
 	return result, item.Value(func(val []byte) error {
 		if len(val) != 24 {
@@ -28,7 +28,7 @@ func getUnaryIndex(item *badger.Item) (*[6]uint32, error) {
 	})
 }
 
-func (uc unaryCache) getIndex(a ID, txn *badger.Txn) (*[6]uint32, error) {
+func (uc unaryCache) getIndex(a ID, txn view) (*[6]uint32, error) {
 	index, has := uc[a]
 	if has {
 		return index, nil
@@ -56,7 +56,7 @@ func (uc unaryCache) getIndex(a ID, txn *badger.Txn) (*[6]uint32, error) {
 	return uc[a], nil
 }
 
-func (uc unaryCache) Get(p Permutation, a ID, txn *badger.Txn) (uint32, error) {
+func (uc unaryCache) Get(p Permutation, a ID, txn view) (uint32, error) {
 	index, err := uc.getIndex(a, txn)
 	if err == badger.ErrKeyNotFound {
 		return 0, nil
@@ -66,8 +66,8 @@ func (uc unaryCache) Get(p Permutation, a ID, txn *badger.Txn) (uint32, error) {
 	return index[p], nil
 }
 
-func (uc unaryCache) Increment(p Permutation, a ID, txn *badger.Txn) error {
-	index, err := uc.getIndex(a, txn)
+func (uc unaryCache) Increment(p Permutation, a ID, st *statistics, txn *badger.Txn) error {
+	index, err := uc.getIndex(a, badgerView{txn})
 	if err == badger.ErrKeyNotFound {
 		index = &[6]uint32{}
 		uc[a] = index
@@ -75,11 +75,15 @@ func (uc unaryCache) Increment(p Permutation, a ID, txn *badger.Txn) error {
 		return err
 	}
 	uc[a][p]++
+	if p < 3 && uc[a][p] == 1 {
+		// a has started to occur in position p
+		st.terms[p]++
+	}
 	return nil
 }
 
-func (uc unaryCache) Decrement(p Permutation, a ID, txn *badger.Txn) error {
-	index, err := uc.getIndex(a, txn)
+func (uc unaryCache) Decrement(p Permutation, a ID, st *statistics, txn *badger.Txn) error {
+	index, err := uc.getIndex(a, badgerView{txn})
 	if err == badger.ErrKeyNotFound {
 		index = &[6]uint32{}
 		uc[a] = index
@@ -88,6 +92,10 @@ func (uc unaryCache) Decrement(p Permutation, a ID, txn *badger.Txn) error {
 	}
 	if uc[a][p] > 0 {
 		uc[a][p]--
+		if p < 3 && uc[a][p] == 0 && st.terms[p] > 0 {
+			// a no longer occurs in position p
+			st.terms[p]--
+		}
 	}
 	return nil
 }
@@ -130,7 +138,7 @@ func newBinaryCache() binaryCache {
 	return binaryCache{}
 }
 
-func (bc binaryCache) Get(p Permutation, a, b ID, txn *badger.Txn) (uint32, error) {
+func (bc binaryCache) Get(p Permutation, a, b ID, txn view) (uint32, error) {
 	key := assembleKey(BinaryPrefixes[p], false, a, b)
 	s := string(key)
 	count, has := bc[s]
@@ -155,7 +163,7 @@ func (bc binaryCache) Get(p Permutation, a, b ID, txn *badger.Txn) (uint32, erro
 	return bc[s], nil
 }
 
-func (bc binaryCache) delta(p Permutation, a, b ID, increment bool, uc unaryCache, txn *badger.Txn) error {
+func (bc binaryCache) delta(p Permutation, a, b ID, increment bool, uc unaryCache, st *statistics, txn *badger.Txn) error {
 	key := assembleKey(BinaryPrefixes[p], false, a, b)
 	s := string(key)
 	_, has := bc[s]
@@ -163,12 +171,12 @@ func (bc binaryCache) delta(p Permutation, a, b ID, increment bool, uc unaryCach
 		if increment {
 			bc[s]++
 			if bc[s] == 1 {
-				return uc.Increment(p, a, txn)
+				return uc.Increment(p, a, st, txn)
 			}
 		} else if bc[s] > 0 {
 			bc[s]--
 			if bc[s] == 0 {
-				return uc.Decrement(p, a, txn)
+				return uc.Decrement(p, a, st, txn)
 			}
 		} else {
 			// ??
@@ -179,7 +187,7 @@ func (bc binaryCache) delta(p Permutation, a, b ID, increment bool, uc unaryCach
 	item, err := txn.Get(key)
 	if err == badger.ErrKeyNotFound && increment { // Hmm
 		bc[s] = 1
-		return uc.Increment(p, a, txn)
+		return uc.Increment(p, a, st, txn)
 	} else if err != nil {
 		return err
 	}
@@ -199,7 +207,7 @@ func (bc binaryCache) delta(p Permutation, a, b ID, increment bool, uc unaryCach
 		bc[s]++
 	} else if bc[s] == 1 {
 		bc[s] = 0
-		return uc.Decrement(p, a, txn)
+		return uc.Decrement(p, a, st, txn)
 	} else if bc[s] > 0 {
 		bc[s]--
 	} else {
@@ -208,12 +216,12 @@ func (bc binaryCache) delta(p Permutation, a, b ID, increment bool, uc unaryCach
 	return nil
 }
 
-func (bc binaryCache) Increment(p Permutation, a, b ID, uc unaryCache, txn *badger.Txn) error {
-	return bc.delta(p, a, b, true, uc, txn)
+func (bc binaryCache) Increment(p Permutation, a, b ID, uc unaryCache, st *statistics, txn *badger.Txn) error {
+	return bc.delta(p, a, b, true, uc, st, txn)
 }
 
-func (bc binaryCache) Decrement(p Permutation, a, b ID, uc unaryCache, txn *badger.Txn) error {
-	return bc.delta(p, a, b, false, uc, txn)
+func (bc binaryCache) Decrement(p Permutation, a, b ID, uc unaryCache, st *statistics, txn *badger.Txn) error {
+	return bc.delta(p, a, b, false, uc, st, txn)
 }
 
 // Commit writes the contents of the index map to badger
@@ -237,3 +245,210 @@ func (bc binaryCache) Commit(db *badger.DB, t *badger.Txn) (txn *badger.Txn, err
 	}
 	return
 }
+
+type graphCache map[ID]uint32
+
+// newGraphCache returns a new graph cache
+func newGraphCache() graphCache {
+	return graphCache{}
+}
+
+func (gc graphCache) Get(g ID, txn view) (uint32, error) {
+	count, has := gc[g]
+	if has {
+		return count, nil
+	}
+
+	item, err := txn.Get(assembleKey(GraphPrefix, false, g))
+	if err == badger.ErrKeyNotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	err = item.Value(func(val []byte) error {
+		if len(val) != 4 {
+			return fmt.Errorf("Unexpected graph value: %v", val)
+		}
+		gc[g] = binary.BigEndian.Uint32(val)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return gc[g], nil
+}
+
+// Increment the number of statements in the graph g
+func (gc graphCache) Increment(g ID, st *statistics, txn *badger.Txn) error {
+	count, err := gc.Get(g, badgerView{txn})
+	if err != nil {
+		return err
+	}
+	gc[g] = count + 1
+	if count == 0 {
+		st.graphs++
+	}
+	return nil
+}
+
+// Decrement the number of statements in the graph g
+func (gc graphCache) Decrement(g ID, st *statistics, txn *badger.Txn) error {
+	count, err := gc.Get(g, badgerView{txn})
+	if err != nil {
+		return err
+	} else if count == 0 {
+		return nil
+	}
+	gc[g] = count - 1
+	if count == 1 && st.graphs > 0 {
+		st.graphs--
+	}
+	return nil
+}
+
+// Commit writes the contents of the graph map to badger
+func (gc graphCache) Commit(db *badger.DB, t *badger.Txn) (txn *badger.Txn, err error) {
+	txn = t
+	for g, count := range gc {
+		key := assembleKey(GraphPrefix, false, g)
+		if count == 0 {
+			txn, err = deleteSafe(key, txn, db)
+			if err == badger.ErrKeyNotFound {
+			} else if err != nil {
+				return
+			}
+		} else {
+			val := make([]byte, 4)
+			binary.BigEndian.PutUint32(val, count)
+			txn, err = setSafe(key, val, txn, db)
+			if err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// statistics are the global counts of the database: the number
+// of distinct triples, the number of distinct terms that occur in
+// each of the subject, predicate, and object positions, and the
+// number of distinct graphs.
+type statistics struct {
+	triples uint32
+	terms   [3]uint32
+	graphs  uint32
+}
+
+// getStatistics reads the statistics from badger
+func getStatistics(txn view) (*statistics, error) {
+	st := &statistics{}
+	item, err := txn.Get(StatisticsKey)
+	if err == badger.ErrKeyNotFound {
+		return st, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return st, item.Value(func(val []byte) error {
+		if len(val) != 20 {
+			return fmt.Errorf("Unexpected statistics value: %v", val)
+		}
+		st.triples = binary.BigEndian.Uint32(val[:4])
+		for i := 0; i < 3; i++ {
+			st.terms[i] = binary.BigEndian.Uint32(val[(i+1)*4 : (i+2)*4])
+		}
+		st.graphs = binary.BigEndian.Uint32(val[16:])
+		return nil
+	})
+}
+
+func (st *statistics) bytes() []byte {
+	val := make([]byte, 20)
+	binary.BigEndian.PutUint32(val[:4], st.triples)
+	for i, c := range st.terms {
+		binary.BigEndian.PutUint32(val[(i+1)*4:(i+2)*4], c)
+	}
+	binary.BigEndian.PutUint32(val[16:], st.graphs)
+	return val
+}
+
+// Commit writes the statistics to badger
+func (st *statistics) Commit(db *badger.DB, txn *badger.Txn) (*badger.Txn, error) {
+	return setSafe(StatisticsKey, st.bytes(), txn, db)
+}
+
+// initStatistics counts the triples, terms, and graphs of databases that
+// were written before the statistics were maintained, and saves them
+// along with the graph index.
+func initStatistics(db *badger.DB) (err error) {
+	st := &statistics{}
+	gc := newGraphCache()
+
+	err = db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(StatisticsKey)
+		if err != badger.ErrKeyNotFound {
+			st = nil
+			return err
+		}
+
+		iter := txn.NewIterator(badger.IteratorOptions{
+			PrefetchValues: true,
+			Prefix:         []byte{TernaryPrefixes[0]},
+		})
+		defer iter.Close()
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			st.triples++
+			var statements []*Statement
+			err = iter.Item().Value(func(val []byte) (err error) {
+				statements, err = getStatements(val)
+				return
+			})
+			if err != nil {
+				return err
+			}
+			for _, statement := range statements {
+				if gc[statement.graph]++; gc[statement.graph] == 1 {
+					st.graphs++
+				}
+			}
+		}
+
+		unary := txn.NewIterator(badger.IteratorOptions{
+			PrefetchValues: true,
+			Prefix:         []byte{UnaryPrefix},
+		})
+		defer unary.Close()
+		for unary.Rewind(); unary.Valid(); unary.Next() {
+			index, err := getUnaryIndex(unary.Item())
+			if err != nil {
+				return err
+			}
+			for p := 0; p < 3; p++ {
+				if index[p] > 0 {
+					st.terms[p]++
+				}
+			}
+		}
+		return nil
+	})
+
+	if err != nil || st == nil {
+		return
+	}
+
+	txn := db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+
+	txn, err = gc.Commit(db, txn)
+	if err != nil {
+		return
+	}
+
+	txn, err = st.Commit(db, txn)
+	if err != nil {
+		return
+	}
+
+	return txn.Commit()
+}
@@ -62,6 +62,15 @@ var ErrInvalidDomain = errors.New("Invalid domain")
 // ErrInvalidIndex means that provided index included blank nodes or that it was too long
 var ErrInvalidIndex = errors.New("Invalid index")
 
+// ErrReverse means that the iterator's query can't be evaluated in descending order
+var ErrReverse = errors.New("Cannot reverse queries with unions or optional groups")
+
+// ErrProjection means that the query's solutions can't be projected onto some of its variables
+var ErrProjection = errors.New("Cannot project queries with optional groups")
+
+// ErrFederation means that some of the stores of a federation don't share term IDs
+var ErrFederation = errors.New("Cannot federate stores that don't use the string dictionary")
+
 // Algorithm has to be URDNA2015
 const Algorithm = "URDNA2015"
 
@@ -71,6 +80,12 @@ const Format = "application/n-quads"
 // SequenceKey to store the id counter
 var SequenceKey = []byte("#")
 
+// StatisticsKey stores the global counts of triples and terms
+var StatisticsKey = []byte("$")
+
+// EncodingKey stores the version of the literal encoding
+var EncodingKey = []byte("%")
+
 // DatasetPrefix keys store the datasets in the database
 const DatasetPrefix = byte(':')
 
@@ -83,6 +98,9 @@ const IDToValuePrefix = byte('<')
 // UnaryPrefix keys translate ld.Node values to uint64 ids
 const UnaryPrefix = byte('u')
 
+// GraphPrefix keys count the statements in each graph
+const GraphPrefix = byte('g')
+
 // TernaryPrefixes address the ternary indices
 var TernaryPrefixes = [3]byte{'a', 'b', 'c'}
 
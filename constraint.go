@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 
 	badger "github.com/dgraph-io/badger/v2"
 	rdf "github.com/underlay/go-rdfjs"
@@ -12,13 +13,21 @@ import (
 // A constraint to an occurrence of a variable in a query
 type constraint struct {
 	index     int         // The index of the triple within the query
-	place     Permutation // The term (subject = 0, predicate = 1, object = 2) within the triple
+	place     Permutation // The term (subject = 0, predicate = 1, object = 2, graph = 3) within the triple
 	count     uint32      // The number of unique triples that satisfy the constraint
 	prefix    []byte
-	iterator  *badger.Iterator
+	iterator  viewIterator
+	scope     []byte // The prefix option of the iterator
+	reverse   bool   // Whether the constraint iterates over its values in descending order
 	quad      *rdf.Quad
 	terms     [3]ID
+	graph     ID   // The graph that the triple has to be asserted in, if any
+	values    []ID // The values of fixed constraints, like the graphs that assert a triple
+	cursor    int  // The index into values
+	txn       view
 	neighbors []*constraint
+	path      *Path    // The path that the constraint is one end of, for path constraints
+	sources   *sources // The datasets that the triple has to be asserted in, if any
 }
 
 // cache is a struct for holding cached value states
@@ -40,30 +49,10 @@ func (c *constraint) print(p Permutation) string {
 	return c.quad[p].String()
 }
 
-func (c *constraint) Sources(value ID, txn *badger.Txn) (statements []*Statement, err error) {
-	var item *badger.Item
-	if c.place == 0 {
-		item = c.iterator.Item()
-	} else {
-		c.terms[c.place] = value
-		s, p, o := c.terms[0], c.terms[1], c.terms[2]
-		key := assembleKey(TernaryPrefixes[0], false, s, p, o)
-		item, err = txn.Get(key)
-		if err != nil {
-			return
-		}
-	}
-
-	err = item.Value(func(val []byte) (err error) {
-		statements, err = getStatements(val)
-		return
-	})
-
-	return
-}
-
 func (c *constraint) String() string {
-	if len(c.prefix) == 9 {
+	if c.path != nil {
+		return fmt.Sprintf("(p%d %s%s #%d)", c.place, c.quad[1], c.path.modifier(), c.count)
+	} else if len(c.prefix) == 9 {
 		return fmt.Sprintf(
 			"(p%d {%s | %s} %s:%02d#%d)",
 			c.place,
@@ -87,6 +76,26 @@ func (c *constraint) String() string {
 	}
 }
 
+// open creates the constraint's badger iterator over the keys with the given scope
+func (c *constraint) open(txn view, scope []byte) {
+	c.scope = scope
+	c.iterator = txn.NewIterator(badger.IteratorOptions{
+		PrefetchValues: false,
+		Prefix:         scope,
+		Reverse:        c.reverse,
+	})
+}
+
+// turn re-opens the constraint's iterator in the given direction.
+// The iterator has to be seeked again afterwards.
+func (c *constraint) turn(reverse bool) {
+	c.reverse = reverse
+	if c.iterator != nil {
+		c.iterator.Close()
+		c.open(c.txn, c.scope)
+	}
+}
+
 // Close the constraint's iterator, if it exists
 func (c *constraint) Close() {
 	if c.iterator != nil {
@@ -95,44 +104,231 @@ func (c *constraint) Close() {
 	}
 }
 
+// free returns true if the constraint is third-degree
+// i.e. none of its terms are fixed by the query
+func (c *constraint) free() bool {
+	return c.place < 3 && len(c.neighbors) >= 3 &&
+		c.neighbors[(c.place+1)%3] != nil &&
+		c.neighbors[(c.place+2)%3] != nil
+}
+
+// fixed returns true if c is ranging over a list of values: either a graph
+// constraint for one particular triple, or a path constraint from a known node
+func (c *constraint) fixed() bool {
+	if c.path != nil {
+		return c.terms[2-c.place] != NIL
+	}
+	return c.place == 3 && c.prefix[0] != GraphPrefix
+}
+
 func (c *constraint) value() (v ID) {
-	if c.iterator.ValidForPrefix(c.prefix) {
+	if c.fixed() {
+		if 0 <= c.cursor && c.cursor < len(c.values) {
+			v = c.values[c.cursor]
+		}
+		return
+	}
+
+	for c.iterator.ValidForPrefix(c.prefix) {
 		item := c.iterator.Item()
+		meta := item.UserMeta()
+		if meta == UnaryPrefix {
+			// The unary index has an entry for every term in the database,
+			// so we skip the ones that never occur in the constraint's place.
+			index, err := getUnaryIndex(item)
+			if err != nil || !c.occurs(index) {
+				c.iterator.Next()
+				continue
+			}
+		}
+
 		key := item.KeyCopy(nil)
 		i := bytes.LastIndexByte(key, '\t')
 		if i == -1 {
 			i = 0
 		}
+
+		if c.repeated() && !c.reflexive(ID(key[i+1:])) {
+			// The binary index doesn't know that the other
+			// variable place has to have the same value
+			c.iterator.Next()
+			continue
+		}
+
+		if (c.graph != NIL || c.sources != nil) && TernaryPrefixes[0] <= meta && meta <= TernaryPrefixes[2] {
+			// The whole triple is known, so we can check its statements
+			if !c.asserted(ID(key[i+1:])) {
+				c.iterator.Next()
+				continue
+			}
+		}
+
 		v = ID(key[i+1:])
+		break
 	}
 
 	return
 }
 
-// Next advances the iterator and returns the next value
+// occurs returns true if a term with the given unary index
+// can be a value of the constraint
+func (c *constraint) occurs(index *[6]uint32) bool {
+	if c.path != nil {
+		// Paths of length zero start and end at any subject or object
+		return index[0] > 0 || index[2] > 0
+	}
+	return index[c.place] > 0
+}
+
+// repeated returns true if the constraint's variable
+// also occurs in the next place of the triple
+func (c *constraint) repeated() bool {
+	if c.place == 3 || c.path != nil {
+		return false
+	}
+	t := c.quad[c.place].TermType()
+	return (t == rdf.BlankNodeType || t == rdf.VariableType) && c.quad[c.place].Equal(c.quad[(c.place+1)%3])
+}
+
+// reflexive returns true if the triple with v in both of the constraint's
+// repeated places is in the database, and in the constraint's datasets.
+// If the third place is a different variable without a value yet,
+// it only checks that v occurs in both places of some triple.
+func (c *constraint) reflexive(v ID) bool {
+	terms := c.terms
+	terms[c.place], terms[(c.place+1)%3] = v, v
+	if p := (c.place + 2) % 3; c.quad[p].Equal(c.quad[c.place]) {
+		terms[p] = v
+	} else if terms[p] == NIL {
+		_, err := c.txn.Get(assembleKey(BinaryPrefixes[c.place], false, v, v))
+		return err == nil
+	}
+	return c.holds(terms)
+}
+
+// asserted returns true if the triple with v in the constraint's place
+// has a statement in the constraint's graph and datasets
+func (c *constraint) asserted(v ID) bool {
+	terms := c.terms
+	terms[c.place] = v
+	return c.holds(terms)
+}
+
+// holds returns true if the triple is in the database with a statement in the
+// constraint's graph, if it has one, and in the constraint's datasets, if it has any
+func (c *constraint) holds(terms [3]ID) (ok bool) {
+	item, err := c.txn.Get(assembleKey(TernaryPrefixes[0], false, terms[:]...))
+	if err != nil {
+		return false
+	} else if c.graph == NIL && c.sources == nil {
+		return true
+	}
+
+	_ = item.Value(func(val []byte) error {
+		statements, err := getStatements(val)
+		for _, statement := range statements {
+			if (c.graph == NIL || statement.graph == c.graph) && c.sources.accepts(statement) {
+				ok = true
+			}
+		}
+		return err
+	})
+
+	return
+}
+
+// Next advances the iterator and returns the next value,
+// which is the previous value if the constraint is reversed
 func (c *constraint) Next() ID {
-	c.iterator.Next()
+	if c.fixed() && c.reverse {
+		c.cursor--
+	} else if c.fixed() {
+		c.cursor++
+	} else {
+		c.iterator.Next()
+	}
 	return c.value()
 }
 
 // Seek advances the iterator to the first value equal to
-// or greater than given byte slice.
+// or greater than given byte slice. Reversed constraints seek
+// to the last value equal to or less than v instead, and seek
+// to their last value if v is NIL.
 func (c *constraint) Seek(v ID) ID {
-	key := make([]byte, len(c.prefix)+len(v))
+	if c.fixed() && c.reverse {
+		c.cursor = sort.Search(len(c.values), func(i int) bool { return v != NIL && c.values[i] > v }) - 1
+		return c.value()
+	} else if c.fixed() {
+		c.cursor = sort.Search(len(c.values), func(i int) bool { return c.values[i] >= v })
+		return c.value()
+	}
+
+	key := make([]byte, len(c.prefix)+len(v), len(c.prefix)+len(v)+1)
 	copy(key, c.prefix)
 	if v != NIL {
 		copy(key[len(c.prefix):], v)
+	} else if c.reverse {
+		// IDs are valid UTF-8, so they never contain 0xFF
+		key = append(key, 0xFF)
 	}
 	c.iterator.Seek(key)
 	return c.value()
 }
 
-func (c *constraint) getCount(uc unaryCache, bc binaryCache, txn *badger.Txn) (uint32, error) {
+// setGraphs sets the values of a graph constraint. If the whole triple is
+// known, they're the graphs that assert it. Otherwise they're every graph.
+func (c *constraint) setGraphs(st *statistics, txn view) (err error) {
+	if c.terms[0] == NIL || c.terms[1] == NIL || c.terms[2] == NIL {
+		c.prefix = []byte{GraphPrefix}
+		c.values = nil
+		c.count = st.graphs
+		return
+	}
+
+	c.prefix = assembleKey(TernaryPrefixes[0], false, c.terms[:]...)
+	c.values = c.values[:0]
+	c.cursor = 0
+	c.count = 0
+
+	item, err := txn.Get(c.prefix)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	var statements []*Statement
+	err = item.Value(func(val []byte) (err error) {
+		statements, err = getStatements(val)
+		return
+	})
+	if err != nil {
+		return
+	}
+
+	for _, statement := range statements {
+		if !c.sources.accepts(statement) {
+			continue
+		}
+
+		i := sort.Search(len(c.values), func(i int) bool { return c.values[i] >= statement.graph })
+		if i == len(c.values) || c.values[i] != statement.graph {
+			c.values = append(c.values, NIL)
+			copy(c.values[i+1:], c.values[i:])
+			c.values[i] = statement.graph
+		}
+	}
+
+	c.count = uint32(len(c.values))
+	return
+}
+
+func (c *constraint) getCount(st *statistics, uc unaryCache, bc binaryCache, txn view) (uint32, error) {
 	j, k := (c.place+1)%3, (c.place+2)%3
 	v, w := c.terms[j], c.terms[k]
 	if v == NIL && w == NIL {
-		// AAAA return the total number of variables??
-		return 48329, nil
+		// The constraint can be any term that occurs in its place
+		return st.terms[c.place], nil
 	} else if v == NIL {
 		return uc.Get(k, w, txn)
 	} else if w == NIL {
@@ -191,9 +387,7 @@ func (cs constraintSet) Seek(v ID) ID {
 
 // Next value (could be improved to not double-check the first constraint)
 func (cs constraintSet) Next() (next ID) {
-	c := cs[0]
-	c.iterator.Next()
-	next = c.value()
+	next = cs[0].Next()
 	if next != NIL && len(cs) > 1 {
 		next = cs.Seek(next)
 	}
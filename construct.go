@@ -0,0 +1,97 @@
+package styx
+
+import (
+	"fmt"
+
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// Construct instantiates the template once for each of the iterator's remaining
+// solutions, and passes every resulting quad to emit exactly once. The variables in
+// the template are replaced with their values in the solution, and the template's
+// blank nodes are replaced with fresh blank nodes for each solution. Quads with
+// unbound variables, or with terms that aren't allowed in their place, are skipped.
+// The quads can be written back to the database with Store.Set.
+func (iter *Iterator) Construct(template []*rdf.Quad, emit func(*rdf.Quad) error) error {
+	if iter.empty {
+		return nil
+	}
+
+	c := newConstruction(template)
+	for d, err := iter.Next(nil); d != nil; d, err = iter.Next(nil) {
+		if err != nil {
+			return err
+		} else if err = c.instantiate(iter, emit); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A construction is the state of a CONSTRUCT template across solutions
+type construction struct {
+	template  []*rdf.Quad
+	seen      map[string]bool
+	solutions int
+}
+
+func newConstruction(template []*rdf.Quad) *construction {
+	return &construction{template: template, seen: map[string]bool{}}
+}
+
+// instantiate emits the quads of the template for the iterator's current solution
+// that haven't been emitted yet
+func (c *construction) instantiate(iter *Iterator, emit func(*rdf.Quad) error) error {
+	n := c.solutions
+	c.solutions++
+
+	for _, quad := range c.template {
+		var terms [4]rdf.Term
+		for p, term := range quad {
+			switch term.TermType() {
+			case rdf.VariableType:
+				terms[p] = iter.Get(term)
+			case rdf.BlankNodeType:
+				// The numeric prefix makes the label unique to the solution
+				terms[p] = rdf.NewBlankNode(fmt.Sprintf("b%d_%s", n, term.Value()))
+			default:
+				terms[p] = term
+			}
+		}
+
+		if !validQuad(terms) {
+			continue
+		}
+
+		result := rdf.NewQuad(terms[0], terms[1], terms[2], terms[3])
+		if s := result.String(); !c.seen[s] {
+			c.seen[s] = true
+			if err := emit(result); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// validQuad checks that every term of the quad is bound and allowed in its place
+func validQuad(terms [4]rdf.Term) bool {
+	for _, term := range terms {
+		if term == nil {
+			return false
+		}
+	}
+
+	s, p, g := terms[0].TermType(), terms[1].TermType(), terms[3].TermType()
+	if s != rdf.NamedNodeType && s != rdf.BlankNodeType {
+		return false
+	} else if p != rdf.NamedNodeType {
+		return false
+	} else if g != rdf.NamedNodeType && g != rdf.DefaultGraphType {
+		return false
+	}
+
+	return terms[2].TermType() != rdf.VariableType
+}
@@ -0,0 +1,140 @@
+package styx
+
+import (
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// Count returns the number of the iterator's remaining solutions that differ
+// in the given node, and advances the iterator past all of them. If nil is passed,
+// the last node in the domain is used, just like Next. Passing an earlier node counts
+// the distinct projections of the solutions onto the domain up to and including it.
+// Count doesn't look up the values of the solutions in the dictionary, and when the
+// values of the counted variable all come from a single index key, it adds up the
+// counts in the index instead of visiting each value.
+func (iter *Iterator) Count(node rdf.Term) (int, error) {
+	if iter.top || iter.empty {
+		return 0, nil
+	} else if err := iter.turn(false); err != nil {
+		return 0, err
+	}
+
+	if iter.branches != nil || len(iter.optionals) > 0 || iter.limited {
+		count := 0
+		for d, err := iter.Next(node); d != nil; d, err = iter.Next(node) {
+			if err != nil {
+				return 0, err
+			}
+			count++
+		}
+		return count, nil
+	}
+
+	i := iter.pivot - 1
+	if node != nil {
+		if index, has := iter.ids[node.String()]; has {
+			i = index
+		}
+	}
+
+	// The current solution is counted if it hasn't been returned by Next yet
+	count := 0
+	if iter.bot {
+		iter.bot = false
+		count++
+	}
+
+	if i < 0 {
+		return count, nil
+	} else if iter.partitions == nil && iter.counts(i) {
+		return iter.countValues(i, count)
+	}
+
+	l := iter.Len()
+	for {
+		tail, err := iter.move(i)
+		if err != nil {
+			return 0, err
+		} else if tail == l {
+			iter.top = true
+			return count, nil
+		}
+		count++
+	}
+}
+
+// countValues adds the rest of the solutions to the count one group of the variables
+// before i at a time, using the count of the only constraint of the variable at i.
+// The current solution is already part of the count.
+func (iter *Iterator) countValues(i int, count int) (int, error) {
+	count += iter.countGroup(i) - 1
+	l := iter.Len()
+	for i > 0 {
+		tail, err := iter.next(i - 1)
+		if err != nil {
+			return 0, err
+		} else if tail == l {
+			break
+		}
+		count += iter.countGroup(i)
+	}
+
+	iter.top = true
+	return count, nil
+}
+
+// countGroup returns the number of values of the variable at i, starting at its
+// current value, that it has before the variables before it change.
+func (iter *Iterator) countGroup(i int) int {
+	u := iter.variables[i]
+	value := u.value
+	if u.Seek(u.root) == value {
+		return int(u.cs[0].count)
+	}
+
+	// The iterator was seeked into the middle of u's values,
+	// so the rest of them have to be counted one at a time.
+	count := 1
+	for u.Seek(value); u.Next() != NIL; count++ {
+	}
+	u.value = u.Seek(value)
+	return count
+}
+
+// counts returns true if every value of the variable at i comes from the
+// single index key of its only constraint, once the variables before it have
+// values, and every one of those values is part of a solution. This is true of
+// the last variable in the domain, and of any variable of a single triple.
+// The index keys count the triples of every dataset, so queries that are
+// restricted to some datasets can't use them, and federated queries can't
+// either, since the stores' counts of the triples they share add up.
+func (iter *Iterator) counts(i int) bool {
+	if _, federated := iter.txn.(federatedView); federated {
+		return false
+	} else if iter.sources != nil || len(iter.variables[i].cs) != 1 {
+		return false
+	} else if i < len(iter.variables)-1 && len(iter.query) != 1 {
+		return false
+	}
+
+	for _, u := range iter.variables[i:] {
+		if len(u.cs) != 1 || len(u.filters) > 0 || u.lower != NIL || u.upper != NIL {
+			return false
+		}
+
+		c := u.cs[0]
+		if c.place == 3 || c.path != nil || c.quad[3].TermType() != rdf.DefaultGraphType {
+			return false
+		}
+
+		for p := 0; p < 3; p++ {
+			t := c.quad[p].TermType()
+			if t == rdf.VariableType || t == rdf.BlankNodeType {
+				if c.quad[p].Equal(c.quad[(p+1)%3]) {
+					return false
+				}
+			}
+		}
+	}
+
+	return true
+}
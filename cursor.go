@@ -0,0 +1,280 @@
+package styx
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// ErrInvalidCursor means that a cursor was malformed, or that it was made by a different query
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// WithCursor resumes the query right after the solution that the cursor was made at,
+// or at the solution itself if Next hadn't returned it yet. The cursor has to come from
+// Iterator.Cursor on an iterator over the same pattern, domain, filters, optional groups,
+// unions, negations, paths, projection, and source, and can't be combined with an index.
+// The limit and the number of goroutines can change from one page to the next. Sources
+// other than AllowDatasets and DenyDatasets are told apart by their String method if
+// they have one, and otherwise only by their type.
+func WithCursor(cursor string) QueryOption {
+	return func(options *queryOptions) {
+		options.cursor = cursor
+	}
+}
+
+// A cursor is the decoded form of the tokens that Iterator.Cursor returns
+type cursor struct {
+	Fingerprint string   `json:"f"`
+	Domain      []string `json:"d"`           // The order of the iterator's domain
+	Values      []ID     `json:"v"`           // The values of the solution
+	Inclusive   bool     `json:"i,omitempty"` // Whether Next hadn't returned the solution yet
+}
+
+// fingerprint hashes the pattern, the domain, and the options of a query
+// that change its solutions
+func fingerprint(pattern []*rdf.Quad, domain []rdf.Term, options *queryOptions) string {
+	h := sha256.New()
+	writePattern(h, pattern)
+	for _, node := range domain {
+		_, _ = io.WriteString(h, node.String())
+		_, _ = io.WriteString(h, "\n")
+	}
+	options.write(h)
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil)[:16])
+}
+
+func writePattern(w io.Writer, pattern []*rdf.Quad) {
+	for _, quad := range pattern {
+		_, _ = io.WriteString(w, quad.String())
+		_, _ = io.WriteString(w, "\n")
+	}
+}
+
+// write describes the options for fingerprint, one clause per line
+func (options *queryOptions) write(w io.Writer) {
+	for _, filter := range options.filters {
+		_, _ = fmt.Fprintf(w, "FILTER %s\n", filter.key)
+	}
+
+	for _, group := range options.optionals {
+		_, _ = io.WriteString(w, "OPTIONAL {\n")
+		writePattern(w, group.pattern)
+		group.options.write(w)
+		_, _ = io.WriteString(w, "}\n")
+	}
+
+	for _, union := range options.unions {
+		_, _ = io.WriteString(w, "UNION\n")
+		for _, alternative := range union {
+			_, _ = io.WriteString(w, "{\n")
+			writePattern(w, alternative)
+			_, _ = io.WriteString(w, "}\n")
+		}
+	}
+
+	for _, n := range options.negations {
+		if n.minus {
+			_, _ = io.WriteString(w, "MINUS {\n")
+		} else {
+			_, _ = io.WriteString(w, "NOT EXISTS {\n")
+		}
+		writePattern(w, n.pattern)
+		n.options.write(w)
+		_, _ = io.WriteString(w, "}\n")
+	}
+
+	for _, path := range options.paths {
+		_, _ = fmt.Fprintf(w, "PATH %s\n", path)
+	}
+
+	if options.projected {
+		_, _ = io.WriteString(w, "SELECT")
+		for _, node := range options.projection {
+			_, _ = io.WriteString(w, " "+node.String())
+		}
+		_, _ = io.WriteString(w, "\n")
+	}
+
+	if s, is := options.source.(fmt.Stringer); is {
+		_, _ = fmt.Fprintf(w, "FROM %s\n", s)
+	} else if options.source != nil {
+		_, _ = fmt.Fprintf(w, "FROM %T\n", options.source)
+	}
+}
+
+// Cursor returns an opaque token for the iterator's current solution, which WithCursor
+// uses to resume the same query from another iterator, even in another process.
+// Cursor returns ErrEndOfSolutions if the iterator doesn't have a current solution.
+func (iter *Iterator) Cursor() (string, error) {
+	if iter.empty || iter.top {
+		return "", ErrEndOfSolutions
+	}
+
+	values, err := iter.values()
+	if err != nil {
+		return "", err
+	}
+
+	c := &cursor{
+		Fingerprint: iter.fingerprint,
+		Domain:      make([]string, len(iter.domain)),
+		Values:      values,
+		Inclusive:   iter.bot,
+	}
+
+	for i, node := range iter.domain {
+		c.Domain[i] = node.String()
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// parseCursor decodes a cursor and checks that it was made by a query with the
+// given pattern, domain, and options. It returns the domain of the cursor's iterator, so
+// that the query orders its variables the same way.
+func parseCursor(token string, pattern []*rdf.Quad, domain []rdf.Term, options *queryOptions) (*cursor, []rdf.Term, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, nil, ErrInvalidCursor
+	}
+
+	c := &cursor{}
+	err = json.Unmarshal(data, c)
+	if err != nil || c.Fingerprint != fingerprint(pattern, domain, options) {
+		return nil, nil, ErrInvalidCursor
+	}
+
+	terms := map[string]rdf.Term{}
+	add := func(pattern []*rdf.Quad) {
+		for _, quad := range pattern {
+			for _, term := range quad {
+				if t := term.TermType(); t == rdf.VariableType || t == rdf.BlankNodeType {
+					terms[term.String()] = term
+				}
+			}
+		}
+	}
+
+	add(pattern)
+	for _, union := range options.unions {
+		for _, alternative := range union {
+			add(alternative)
+		}
+	}
+	for _, path := range options.paths {
+		terms[path.subject.String()] = path.subject
+		terms[path.object.String()] = path.object
+	}
+
+	order := make([]rdf.Term, len(c.Domain))
+	for i, value := range c.Domain {
+		term, has := terms[value]
+		if !has {
+			return nil, nil, ErrInvalidCursor
+		}
+		order[i] = term
+	}
+
+	return c, order, nil
+}
+
+// distinct returns the number of nodes at the start of the
+// domain whose values Next(nil) moves through
+func (iter *Iterator) distinct() int {
+	if iter.branches != nil {
+		return len(iter.domain)
+	}
+	return iter.pivot
+}
+
+// values returns the IDs of the current solution's values for the nodes that Next(nil)
+// moves through, followed by the values of the optional variables. The IDs of unbound
+// variables are NIL.
+func (iter *Iterator) values() ([]ID, error) {
+	index := iter.Index()
+	k, l := iter.distinct(), len(iter.domain)
+	values := make([]ID, 0, k+len(index)-l)
+	for _, term := range append(index[:k:k], index[l:]...) {
+		if term == nil {
+			values = append(values, NIL)
+			continue
+		}
+
+		id, err := iter.dictionary.GetID(term, rdf.Default)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, id)
+	}
+	return values, nil
+}
+
+// resume seeks the iterator to the cursor's solution, or to the first solution
+// after it if the cursor's solution was already returned or doesn't exist anymore.
+func (iter *Iterator) resume(c *cursor) (err error) {
+	if iter.empty || iter.top {
+		return
+	}
+
+	k := iter.distinct()
+	if len(c.Values) != k+len(iter.optionalDomain) {
+		return ErrInvalidCursor
+	}
+
+	// The solutions of unions and optional groups can have unbound values,
+	// so the iterator seeks to the bound values and walks the rest of the way.
+	index := make([]rdf.Term, 0, k)
+	for _, id := range c.Values[:k] {
+		if id == NIL {
+			break
+		}
+
+		var term rdf.Term
+		term, err = iter.dictionary.GetTerm(id, rdf.Default)
+		if err != nil {
+			return
+		}
+		index = append(index, term)
+	}
+
+	err = iter.Seek(index)
+	if err != nil {
+		return
+	}
+
+	iter.bot = false
+	for !iter.top {
+		var values []ID
+		values, err = iter.values()
+		if err != nil {
+			return
+		}
+
+		order := compareTuples(values, c.Values, k)
+		if order > 0 {
+			break
+		} else if order == 0 && compareTuples(values[k:], c.Values[k:], len(values)-k) == 0 {
+			if !c.Inclusive {
+				_, err = iter.advance(nil)
+			}
+			break
+		}
+
+		if _, err = iter.advance(nil); err != nil {
+			return
+		}
+	}
+
+	iter.bot = !iter.top
+	return
+}
@@ -40,6 +40,12 @@ func deleteQuads(origin ID, quads [][4]ID, dictionary Dictionary, t *badger.Txn,
 
 	bc := newBinaryCache()
 	uc := newUnaryCache()
+	gc := newGraphCache()
+
+	st, err := getStatistics(badgerView{txn})
+	if err != nil {
+		return
+	}
 
 	for _, quad := range quads {
 		terms := [3]ID{quad[0], quad[1], quad[2]}
@@ -67,6 +73,8 @@ func deleteQuads(origin ID, quads [][4]ID, dictionary Dictionary, t *badger.Txn,
 		for _, x := range statements {
 			if ID(x.base) != origin {
 				val = append(val, x.String()...)
+			} else if err = gc.Decrement(x.graph, st, txn); err != nil {
+				return
 			}
 		}
 		if len(val) > 0 {
@@ -75,12 +83,12 @@ func deleteQuads(origin ID, quads [][4]ID, dictionary Dictionary, t *badger.Txn,
 				return
 			}
 		} else {
-			err = bc.Decrement(0, terms[0], terms[1], uc, txn)
+			err = bc.Decrement(0, terms[0], terms[1], uc, st, txn)
 			if err != nil {
 				return
 			}
 
-			err = bc.Decrement(3, terms[0], terms[2], uc, txn)
+			err = bc.Decrement(3, terms[0], terms[2], uc, st, txn)
 			if err != nil {
 				return
 			}
@@ -89,15 +97,20 @@ func deleteQuads(origin ID, quads [][4]ID, dictionary Dictionary, t *badger.Txn,
 			if err != nil {
 				return
 			}
+
+			if st.triples > 0 {
+				st.triples--
+			}
+
 			for p := Permutation(1); p < 3; p++ {
 				a, b, c := major.permute(p, terms)
 
-				err = bc.Decrement(p, terms[p], terms[(p+1)%3], uc, txn)
+				err = bc.Decrement(p, terms[p], terms[(p+1)%3], uc, st, txn)
 				if err != nil {
 					return
 				}
 
-				err = bc.Decrement(p+3, terms[p], terms[(p+2)%3], uc, txn)
+				err = bc.Decrement(p+3, terms[p], terms[(p+2)%3], uc, st, txn)
 				if err != nil {
 					return
 				}
@@ -124,5 +137,15 @@ func deleteQuads(origin ID, quads [][4]ID, dictionary Dictionary, t *badger.Txn,
 		return
 	}
 
+	txn, err = gc.Commit(db, txn)
+	if err != nil {
+		return
+	}
+
+	txn, err = st.Commit(db, txn)
+	if err != nil {
+		return
+	}
+
 	return
 }
@@ -3,6 +3,8 @@ package styx
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"math"
 	"regexp"
 	"strings"
 
@@ -232,6 +234,10 @@ func (d *iriDictionary) GetID(term rdf.Term, origin rdf.Term) (ID, error) {
 		}
 		return ID(string(id) + "#" + value), nil
 	case *rdf.Literal:
+		if class, key, ok := getOrder(term); ok {
+			id, err := d.getIRI(term.Datatype().Value())
+			return ID(string([]byte{orderedPrefix, class}) + key + string(id) + "\"" + escape(value)), err
+		}
 		escaped := "\"" + escape(value) + "\""
 		datatype, language := term.Datatype(), term.Language()
 		if datatype == nil || datatype.Equal(rdf.XSDString) {
@@ -297,6 +303,11 @@ func (d *iriDictionary) GetTerm(id ID, origin rdf.Term) (rdf.Term, error) {
 
 	s := string(id)
 
+	// Ordered literal?
+	if len(s) > 1 && s[0] == orderedPrefix {
+		return d.getOrderedLiteral(s)
+	}
+
 	// Literal?
 	li := patternLiteral.FindStringIndex(s)
 	if li != nil && li[0] == 0 {
@@ -368,3 +379,108 @@ func (d *iriDictionary) Commit() error {
 	d.txn.Discard()
 	return nil
 }
+
+// Discard releases the dictionary without writing the IRIs that it added
+func (d *iriDictionary) Discard() {
+	if d.txn != nil {
+		d.txn.Discard()
+	}
+}
+
+// Numeric, xsd:date, and xsd:dateTime literals get IDs that start with
+// orderedPrefix, a class byte, and a fixed-width key, so that their byte
+// order is the same as the order of their values. The datatype IRI and the
+// lexical form come after the key, so distinct literals with equal values
+// (like "1" and "01") still get distinct IDs.
+const orderedPrefix = byte('^')
+
+var orderedWidths = map[byte]int{'n': 16, 'd': 24, 't': 24}
+
+// getOrder returns the class and key of an ordered literal. It parses
+// literals the same way that compare does, so that every pair of literals
+// that compare can order get IDs in the same order.
+func getOrder(term *rdf.Literal) (class byte, key string, ok bool) {
+	datatype := getDatatype(term).Value()
+	if numericTypes[datatype] {
+		f, ok := parseNumber(term.Value())
+		if !ok {
+			return 0, "", false
+		} else if f == 0 {
+			f = 0 // -0 == 0
+		}
+
+		bits := math.Float64bits(f)
+		if f < 0 {
+			bits = ^bits
+		} else {
+			bits = bits | 1<<63
+		}
+		return 'n', fmt.Sprintf("%016x", bits), true
+	} else if datatype == xsdDate || datatype == xsdDateTime {
+		t, err := parseTime(datatype, term.Value())
+		if err != nil {
+			return 0, "", false
+		}
+
+		class = 't'
+		if datatype == xsdDate {
+			class = 'd'
+		}
+		seconds := uint64(t.Unix()) ^ 1<<63
+		return class, fmt.Sprintf("%016x%08x", seconds, t.Nanosecond()), true
+	}
+	return 0, "", false
+}
+
+func (d *iriDictionary) getOrderedLiteral(s string) (rdf.Term, error) {
+	width, has := orderedWidths[s[1]]
+	if !has || len(s) < 2+width {
+		return nil, ErrInvalidTerm
+	}
+
+	tail := s[2+width:]
+	i := strings.IndexByte(tail, '"')
+	if i == -1 {
+		return nil, ErrInvalidTerm
+	}
+
+	datatype, err := d.getValue(iri(tail[:i]))
+	if err != nil {
+		return nil, err
+	}
+
+	return rdf.NewLiteral(unescape(tail[i+1:]), "", rdf.NewNamedNode(datatype)), nil
+}
+
+// getRange returns the range [lower, upper) of IDs of the literals
+// that satisfy a comparison with the given literal. Literals that
+// aren't ordered by value have no range.
+func (d *iriDictionary) getRange(op string, value *rdf.Literal) (lower, upper ID, ok bool) {
+	class, key, ok := getOrder(value)
+	if !ok {
+		return NIL, NIL, false
+	}
+
+	start := string([]byte{orderedPrefix, class})
+	end := string([]byte{orderedPrefix, class + 1})
+	switch op {
+	case "=":
+		return ID(start + key), ID(start + key + "\xff"), true
+	case "<":
+		return ID(start), ID(start + key), true
+	case "<=":
+		return ID(start), ID(start + key + "\xff"), true
+	case ">":
+		return ID(start + key + "\xff"), ID(end), true
+	case ">=":
+		return ID(start + key), ID(end), true
+	default:
+		return NIL, NIL, false
+	}
+}
+
+// A rangeDictionary is a dictionary whose IDs for some literals sort in the same
+// order as the literals' values, so that filters can seek straight to a range of IDs
+type rangeDictionary interface {
+	getRange(op string, value *rdf.Literal) (lower, upper ID, ok bool)
+}
@@ -0,0 +1,91 @@
+package styx
+
+import (
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// An Explanation describes how an iterator evaluates its query: the order
+// of its variables after sorting, and the constraints that each of them
+// intersects. Unions have an explanation for each branch instead.
+type Explanation struct {
+	Variables []*VariablePlan `json:"variables,omitempty"`
+	Branches  []*Explanation  `json:"branches,omitempty"`
+}
+
+// A VariablePlan describes one variable of a query, with the indices of the
+// variables that it depends on and of the variables that depend on it.
+type VariablePlan struct {
+	Node        rdf.Term          `json:"node"`
+	Norm        uint64            `json:"norm"`
+	Score       float64           `json:"score"`
+	Constraints []*ConstraintPlan `json:"constraints"`
+	In          []int             `json:"in"`
+	Out         []int             `json:"out"`
+}
+
+// A ConstraintPlan describes one occurrence of a variable in the query.
+// Count is the number of values that the constraint had when the variables
+// were scored, and Prefix is the key prefix of the index it was read from.
+type ConstraintPlan struct {
+	Quad   *rdf.Quad `json:"quad"`
+	Index  int       `json:"index"`
+	Place  int       `json:"place"`
+	Prefix string    `json:"prefix"`
+	Count  uint32    `json:"count"`
+}
+
+// Explain returns the plan of the query. The counts of the constraints change
+// as the iterator moves, so the plan is recorded when the iterator is created.
+func (iter *Iterator) Explain() *Explanation {
+	if iter.branches != nil {
+		e := &Explanation{Branches: make([]*Explanation, len(iter.branches))}
+		for i, branch := range iter.branches {
+			e.Branches[i] = branch.Explain()
+		}
+		return e
+	} else if iter.plan == nil {
+		return &Explanation{Variables: []*VariablePlan{}}
+	}
+	return iter.plan
+}
+
+// explain builds the plan from the sorted and connected constraint graph
+func (iter *Iterator) explain() *Explanation {
+	e := &Explanation{Variables: make([]*VariablePlan, len(iter.variables))}
+	for i, u := range iter.variables {
+		e.Variables[i] = &VariablePlan{
+			Node:        u.node,
+			Norm:        u.norm,
+			Score:       u.score,
+			Constraints: constraintPlans(u.cs),
+			In:          append([]int{}, iter.in[i]...),
+			Out:         append([]int{}, iter.out[i]...),
+		}
+	}
+	return e
+}
+
+func constraintPlans(cs constraintSet) []*ConstraintPlan {
+	plans := make([]*ConstraintPlan, len(cs))
+	for i, c := range cs {
+		plans[i] = &ConstraintPlan{
+			Quad:   c.quad,
+			Index:  c.index,
+			Place:  int(c.place),
+			Prefix: c.indexPrefix(),
+			Count:  c.count,
+		}
+	}
+	return plans
+}
+
+// indexPrefix returns the prefix of the index that the constraint reads,
+// or an empty string if its values were all read in advance.
+func (c *constraint) indexPrefix() string {
+	if c.place == 3 {
+		return string(GraphPrefix)
+	} else if len(c.prefix) == 0 {
+		return ""
+	}
+	return string(c.prefix[:1])
+}
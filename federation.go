@@ -0,0 +1,46 @@
+package styx
+
+import (
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// A Federation evaluates queries over the union of several stores, as if their
+// datasets were all in one store. The stores have to use the StringDictionary,
+// so that every store gives each term the same ID.
+type Federation struct {
+	stores []*Store
+}
+
+// NewFederation creates a federation of the given stores. The first store's
+// tag scheme and planner are used for the federation's queries.
+func NewFederation(stores ...*Store) (*Federation, error) {
+	if len(stores) == 0 {
+		return nil, ErrFederation
+	}
+
+	for _, s := range stores {
+		if s.Config.Dictionary != StringDictionary {
+			return nil, ErrFederation
+		}
+	}
+
+	return &Federation{stores: stores}, nil
+}
+
+// Query evaluates the pattern over the union of the federation's stores.
+// The constraints of the query merge the stores' indices in order, and the
+// planner sums their counts, so the iterator behaves just like the iterator
+// of a single store holding all of their triples. Triples that more than one
+// store has are only part of each solution once.
+func (f *Federation) Query(pattern []*rdf.Quad, domain []rdf.Term, index []rdf.Term, options ...QueryOption) (*Iterator, error) {
+	s := f.stores[0]
+	return query(pattern, domain, index, options, s.Config.TagScheme, s.Config.Planner, f.open)
+}
+
+func (f *Federation) open() (view, Dictionary) {
+	views := make(federatedView, len(f.stores))
+	for i, s := range f.stores {
+		views[i] = badgerView{s.Badger.NewTransaction(false)}
+	}
+	return views, StringDictionary.Open(false)
+}
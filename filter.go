@@ -0,0 +1,446 @@
+package styx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ld "github.com/piprate/json-gold/ld"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// ErrInvalidFilter means that a filter referred to a node that wasn't in the query
+var ErrInvalidFilter = errors.New("Invalid filter")
+
+// An Expression is a value in a filter: either a term from the query,
+// or a function of another expression. Expressions evaluate to nil
+// when their nodes are unbound or when they're applied to the wrong
+// kind of term, and filters with nil operands always fail.
+type Expression interface {
+	evaluate(get func(rdf.Term) rdf.Term) rdf.Term
+	nodes() []rdf.Term
+	key() string
+}
+
+type nodeExpression struct{ term rdf.Term }
+
+// Node returns an expression for a constant term, or for the
+// value of a variable or blank node in the query.
+func Node(term rdf.Term) Expression { return nodeExpression{term} }
+
+func (e nodeExpression) evaluate(get func(rdf.Term) rdf.Term) rdf.Term {
+	switch e.term.TermType() {
+	case rdf.VariableType, rdf.BlankNodeType:
+		return get(e.term)
+	default:
+		return e.term
+	}
+}
+
+func (e nodeExpression) nodes() []rdf.Term {
+	switch e.term.TermType() {
+	case rdf.VariableType, rdf.BlankNodeType:
+		return []rdf.Term{e.term}
+	default:
+		return nil
+	}
+}
+
+func (e nodeExpression) key() string { return e.term.String() }
+
+type functionExpression struct {
+	name string
+	arg  Expression
+	f    func(rdf.Term) rdf.Term
+}
+
+func (e functionExpression) evaluate(get func(rdf.Term) rdf.Term) rdf.Term {
+	if term := e.arg.evaluate(get); term != nil {
+		return e.f(term)
+	}
+	return nil
+}
+
+func (e functionExpression) nodes() []rdf.Term { return e.arg.nodes() }
+
+func (e functionExpression) key() string { return e.name + "(" + e.arg.key() + ")" }
+
+// Lang returns an expression for the language tag of a literal,
+// which is the empty string for literals without one.
+func Lang(e Expression) Expression {
+	return functionExpression{"LANG", e, func(term rdf.Term) rdf.Term {
+		if term, is := term.(*rdf.Literal); is {
+			return rdf.NewLiteral(term.Language(), "", rdf.XSDString)
+		}
+		return nil
+	}}
+}
+
+// Datatype returns an expression for the datatype IRI of a literal
+func Datatype(e Expression) Expression {
+	return functionExpression{"DATATYPE", e, func(term rdf.Term) rdf.Term {
+		if term, is := term.(*rdf.Literal); is {
+			return getDatatype(term)
+		}
+		return nil
+	}}
+}
+
+// Str returns an expression for the lexical form of a literal or IRI
+func Str(e Expression) Expression {
+	return functionExpression{"STR", e, func(term rdf.Term) rdf.Term {
+		switch term.TermType() {
+		case rdf.NamedNodeType, rdf.LiteralType:
+			return rdf.NewLiteral(term.Value(), "", rdf.XSDString)
+		default:
+			return nil
+		}
+	}}
+}
+
+// A Filter is a test on the values of the variables and blank nodes in a query.
+// Filters get evaluated as soon as all of their nodes have values, and prune
+// the candidate values of the last of those nodes in the iterator's domain.
+type Filter struct {
+	test   func(get func(rdf.Term) rdf.Term) (result, valid bool)
+	terms  []rdf.Term
+	bounds []bound
+	key    string // The filter's expression, which cursors use to tell filters apart
+}
+
+// A bound is a comparison between a node and a constant literal,
+// which dictionaries with ordered IDs can turn into a range of IDs.
+type bound struct {
+	node  rdf.Term
+	op    string
+	value *rdf.Literal
+}
+
+// bind returns a filter that uses the given values for some of its nodes
+func (filter *Filter) bind(bindings map[string]rdf.Term) *Filter {
+	result := &Filter{
+		terms:  make([]rdf.Term, 0, len(filter.terms)),
+		bounds: make([]bound, 0, len(filter.bounds)),
+		key:    filter.key,
+		test: func(get func(rdf.Term) rdf.Term) (bool, bool) {
+			return filter.test(func(node rdf.Term) rdf.Term {
+				if value, has := bindings[node.String()]; has {
+					return value
+				}
+				return get(node)
+			})
+		},
+	}
+
+	for _, term := range filter.terms {
+		if _, has := bindings[term.String()]; !has {
+			result.terms = append(result.terms, term)
+		}
+	}
+
+	for _, b := range filter.bounds {
+		if _, has := bindings[b.node.String()]; !has {
+			result.bounds = append(result.bounds, b)
+		}
+	}
+
+	return result
+}
+
+// flip reverses the operands of a comparison operator
+var flip = map[string]string{"=": "=", "<": ">", "<=": ">=", ">": "<", ">=": "<="}
+
+func getBounds(a Expression, op string, b Expression) []bound {
+	if op == "!=" {
+		return nil
+	}
+
+	x, is := a.(nodeExpression)
+	if !is {
+		return nil
+	}
+	y, is := b.(nodeExpression)
+	if !is {
+		return nil
+	}
+
+	if value, is := y.term.(*rdf.Literal); is && x.nodes() != nil {
+		return []bound{bound{x.term, op, value}}
+	} else if value, is := x.term.(*rdf.Literal); is && y.nodes() != nil {
+		return []bound{bound{y.term, flip[op], value}}
+	}
+	return nil
+}
+
+// Compare returns a filter that compares two expressions with one of the
+// operators "=", "!=", "<", "<=", ">", ">=". Numeric literals are compared
+// by value, as are xsd:dateTime and xsd:date literals, and other literals are
+// compared by their lexical form if they have the same datatype and language.
+// The ordering operators fail for any other pair of terms.
+func Compare(a Expression, op string, b Expression) (*Filter, error) {
+	var accept func(c int) bool
+	switch op {
+	case "=":
+		accept = func(c int) bool { return c == 0 }
+	case "!=":
+		accept = func(c int) bool { return c != 0 }
+	case "<":
+		accept = func(c int) bool { return c < 0 }
+	case "<=":
+		accept = func(c int) bool { return c <= 0 }
+	case ">":
+		accept = func(c int) bool { return c > 0 }
+	case ">=":
+		accept = func(c int) bool { return c >= 0 }
+	default:
+		return nil, fmt.Errorf("Invalid comparison operator: %s", op)
+	}
+
+	equality := op == "=" || op == "!="
+	return &Filter{
+		terms:  append(a.nodes(), b.nodes()...),
+		bounds: getBounds(a, op, b),
+		key:    "(" + a.key() + " " + op + " " + b.key() + ")",
+		test: func(get func(rdf.Term) rdf.Term) (bool, bool) {
+			x, y := a.evaluate(get), b.evaluate(get)
+			if x == nil || y == nil {
+				return false, false
+			}
+
+			c, ok := compare(x, y)
+			if ok {
+				return accept(c), true
+			} else if equality {
+				// Terms that can't be ordered are only ever equal to themselves
+				if equal(x, y) {
+					return accept(0), true
+				}
+				return accept(1), true
+			}
+			return false, false
+		},
+	}, nil
+}
+
+// Regex returns a filter that tests whether the lexical form of
+// a literal matches the regular expression pattern. The flags are
+// the same as SPARQL's REGEX: "i", "s", and "m".
+func Regex(e Expression, pattern, flags string) (*Filter, error) {
+	if flags != "" {
+		for _, flag := range flags {
+			if flag != 'i' && flag != 's' && flag != 'm' {
+				return nil, fmt.Errorf("Invalid regular expression flag: %c", flag)
+			}
+		}
+		pattern = "(?" + flags + ")" + pattern
+	}
+
+	r, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{
+		terms: e.nodes(),
+		key:   "REGEX(" + e.key() + ", " + strconv.Quote(pattern) + ")",
+		test: func(get func(rdf.Term) rdf.Term) (bool, bool) {
+			term := e.evaluate(get)
+			if term == nil || term.TermType() != rdf.LiteralType {
+				return false, false
+			}
+			return r.MatchString(term.Value()), true
+		},
+	}, nil
+}
+
+// Not returns a filter that negates another filter
+func Not(filter *Filter) *Filter {
+	return &Filter{
+		terms: filter.terms,
+		key:   "!" + filter.key,
+		test: func(get func(rdf.Term) rdf.Term) (bool, bool) {
+			result, valid := filter.test(get)
+			return !result && valid, valid
+		},
+	}
+}
+
+// And returns a filter that passes if every one of the given filters passes
+func And(filters ...*Filter) *Filter {
+	terms, bounds, keys := []rdf.Term{}, []bound{}, make([]string, len(filters))
+	for i, filter := range filters {
+		terms = append(terms, filter.terms...)
+		bounds = append(bounds, filter.bounds...)
+		keys[i] = filter.key
+	}
+	return &Filter{
+		terms:  terms,
+		bounds: bounds,
+		key:    "(" + strings.Join(keys, " && ") + ")",
+		test: func(get func(rdf.Term) rdf.Term) (bool, bool) {
+			valid := true
+			for _, filter := range filters {
+				result, v := filter.test(get)
+				if v && !result {
+					return false, true
+				}
+				valid = valid && v
+			}
+			return valid, valid
+		},
+	}
+}
+
+// Or returns a filter that passes if any one of the given filters passes
+func Or(filters ...*Filter) *Filter {
+	terms, keys := []rdf.Term{}, make([]string, len(filters))
+	for i, filter := range filters {
+		terms = append(terms, filter.terms...)
+		keys[i] = filter.key
+	}
+	return &Filter{
+		terms: terms,
+		key:   "(" + strings.Join(keys, " || ") + ")",
+		test: func(get func(rdf.Term) rdf.Term) (bool, bool) {
+			valid := true
+			for _, filter := range filters {
+				result, v := filter.test(get)
+				if v && result {
+					return true, true
+				}
+				valid = valid && v
+			}
+			return false, valid
+		},
+	}
+}
+
+var xsdDate = ld.XSDNS + "date"
+var xsdDateTime = ld.XSDNS + "dateTime"
+
+var numericTypes = map[string]bool{
+	ld.XSDInteger:                   true,
+	ld.XSDDecimal:                   true,
+	ld.XSDDouble:                    true,
+	ld.XSDFloat:                     true,
+	ld.XSDNS + "int":                true,
+	ld.XSDNS + "long":               true,
+	ld.XSDNS + "short":              true,
+	ld.XSDNS + "byte":               true,
+	ld.XSDNS + "nonNegativeInteger": true,
+	ld.XSDNS + "nonPositiveInteger": true,
+	ld.XSDNS + "positiveInteger":    true,
+	ld.XSDNS + "negativeInteger":    true,
+	ld.XSDNS + "unsignedInt":        true,
+	ld.XSDNS + "unsignedLong":       true,
+	ld.XSDNS + "unsignedShort":      true,
+	ld.XSDNS + "unsignedByte":       true,
+}
+
+func getDatatype(term *rdf.Literal) *rdf.NamedNode {
+	if datatype := term.Datatype(); datatype != nil {
+		return rdf.NewNamedNode(datatype.Value())
+	} else if term.Language() != "" {
+		return rdf.NewNamedNode(ld.RDFLangString)
+	}
+	return rdf.NewNamedNode(ld.XSDString)
+}
+
+// compare orders two literals, and returns false if they're not comparable
+func compare(a, b rdf.Term) (int, bool) {
+	x, is := a.(*rdf.Literal)
+	if !is {
+		return 0, false
+	}
+	y, is := b.(*rdf.Literal)
+	if !is {
+		return 0, false
+	}
+
+	s, t := getDatatype(x).Value(), getDatatype(y).Value()
+	if numericTypes[s] && numericTypes[t] {
+		m, ok := parseNumber(x.Value())
+		if !ok {
+			return 0, false
+		}
+		n, ok := parseNumber(y.Value())
+		if !ok {
+			return 0, false
+		}
+		return compareFloats(m, n), true
+	} else if s != t || x.Language() != y.Language() {
+		return 0, false
+	} else if s == xsdDateTime || s == xsdDate {
+		m, err := parseTime(s, x.Value())
+		if err != nil {
+			return 0, false
+		}
+		n, err := parseTime(s, y.Value())
+		if err != nil {
+			return 0, false
+		}
+		if m.Before(n) {
+			return -1, true
+		} else if m.After(n) {
+			return 1, true
+		}
+		return 0, true
+	} else if s == ld.XSDString || s == ld.RDFLangString || s == ld.XSDBoolean {
+		return strings.Compare(x.Value(), y.Value()), true
+	}
+	return 0, false
+}
+
+// equal compares two terms like Equal does, except that literals without a
+// datatype are the same as xsd:string or rdf:langString literals.
+// The literals' Equal methods dereference the datatype of only one of them.
+func equal(a, b rdf.Term) bool {
+	if a.TermType() != b.TermType() || a.Value() != b.Value() {
+		return false
+	}
+
+	x, is := a.(*rdf.Literal)
+	if !is {
+		return a.Equal(b)
+	}
+	y, is := b.(*rdf.Literal)
+	return is && x.Language() == y.Language() && getDatatype(x).Value() == getDatatype(y).Value()
+}
+
+func compareFloats(m, n float64) int {
+	if m < n {
+		return -1
+	} else if m > n {
+		return 1
+	}
+	return 0
+}
+
+// parseNumber parses the lexical form of a numeric literal.
+// NaN isn't comparable to anything, so it isn't a number here.
+func parseNumber(value string) (float64, bool) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, false
+	}
+	return f, true
+}
+
+func parseTime(datatype, value string) (time.Time, error) {
+	if datatype == xsdDate {
+		t, err := time.Parse("2006-01-02Z07:00", value)
+		if err != nil {
+			t, err = time.Parse("2006-01-02", value)
+		}
+		return t, err
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		t, err = time.Parse("2006-01-02T15:04:05.999999999", value)
+	}
+	return t, err
+}
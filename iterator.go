@@ -7,7 +7,6 @@ import (
 	"strings"
 	"text/tabwriter"
 
-	badger "github.com/dgraph-io/badger/v2"
 	rdf "github.com/underlay/go-rdfjs"
 )
 
@@ -28,9 +27,34 @@ type Iterator struct {
 	out        [][]int
 	binary     binaryCache
 	unary      unaryCache
+	statistics *statistics
 	tag        TagScheme
-	txn        *badger.Txn
+	planner    Planner
+	txn        view
 	dictionary Dictionary
+
+	optionals      []*optional
+	optionalDomain []rdf.Term // The variables that only occur in optional groups
+
+	branches []*Iterator // The iterators for each branch of a union
+	branch   int         // The index of the branch with the current solution
+
+	plan *Explanation // The plan of the query, recorded before the first solution
+
+	limit    int // The number of solutions that Next returns after each Seek, if limited
+	limited  bool
+	returned int // The number of solutions that Next has returned since the last Seek
+
+	partitions []*partition // The ranges of the first variable that are evaluated in parallel
+	partition  int          // The index of the partition with the current solution
+
+	reverse bool // Whether the iterator is moving through its solutions in descending order
+
+	fingerprint string // The hash of the query that its cursors carry
+
+	projection map[string]bool // The variables that the query is projected onto, if it's projected
+
+	sources *sources // The datasets that the query is restricted to, if it's restricted
 }
 
 // Collect calls Next(nil) on the iterator until there are no more solutions,
@@ -77,7 +101,9 @@ func (iter *Iterator) Log() {
 		values := make([]string, len(domain))
 		start := len(domain) - len(d)
 		for i, node := range d {
-			values[start+i] = node.String()
+			if node != nil {
+				values[start+i] = node.String()
+			}
 		}
 		fmt.Fprintln(w, strings.Join(values, "\t"))
 	}
@@ -90,13 +116,17 @@ func (iter *Iterator) Graph() []*rdf.Quad {
 		return nil
 	}
 
+	if iter.branches != nil {
+		return iter.branches[iter.branch].Graph()
+	}
+
 	graph := make([]*rdf.Quad, len(iter.query))
 	for i, quad := range iter.query {
 		graph[i] = rdf.NewQuad(
 			iter.variate(quad[0]),
 			iter.variate(quad[1]),
 			iter.variate(quad[2]),
-			rdf.Default,
+			iter.variate(quad[3]),
 		)
 	}
 	return graph
@@ -113,34 +143,12 @@ func (iter *Iterator) variate(term rdf.Term) rdf.Term {
 	}
 }
 
-// Prov returns a matrix of graph sources
-func (iter *Iterator) Prov() ([][]rdf.Term, error) {
-	ids := make([][]rdf.Term, len(iter.query))
-	for _, u := range iter.variables {
-		for _, c := range u.cs {
-			if ids[c.index] == nil &&
-				TernaryPrefixes[0] <= c.prefix[0] &&
-				c.prefix[0] <= TernaryPrefixes[2] {
-				statements, err := c.Sources(u.value, iter.txn)
-				if err != nil {
-					return nil, err
-				}
-
-				ids[c.index] = make([]rdf.Term, len(statements))
-				for i, statement := range statements {
-					ids[c.index][i] = statement.Graph(iter.dictionary)
-				}
-			}
-		}
-	}
-
-	return ids, nil
-}
-
 // Get the value for a particular blank node
 func (iter *Iterator) Get(node rdf.Term) rdf.Term {
 	if iter.empty || node == nil {
 		return nil
+	} else if iter.branches != nil {
+		return iter.branches[iter.branch].Get(node)
 	}
 
 	var value string
@@ -155,7 +163,7 @@ func (iter *Iterator) Get(node rdf.Term) rdf.Term {
 
 	i, has := iter.ids[value]
 	if !has {
-		return nil
+		return iter.getOptional(node)
 	}
 
 	v := iter.variables[i]
@@ -173,9 +181,9 @@ func (iter *Iterator) Domain() []rdf.Term {
 		return nil
 	}
 
-	domain := make([]rdf.Term, len(iter.domain))
+	domain := make([]rdf.Term, len(iter.domain), len(iter.domain)+len(iter.optionalDomain))
 	copy(domain, iter.domain)
-	return domain
+	return append(domain, iter.optionalDomain...)
 }
 
 // Index returns the iterator's current value as an ordered slice of ld.Nodes
@@ -184,16 +192,38 @@ func (iter *Iterator) Index() []rdf.Term {
 		return nil
 	}
 
-	index := make([]rdf.Term, len(iter.variables))
+	if iter.branches != nil {
+		return iter.row(iter.branches[iter.branch])
+	}
+
+	index := make([]rdf.Term, len(iter.variables), len(iter.variables)+len(iter.optionalDomain))
 	for i, v := range iter.variables {
 		index[i], _ = iter.dictionary.GetTerm(v.value, rdf.Default)
 	}
+	for _, node := range iter.optionalDomain {
+		index = append(index, iter.getOptional(node))
+	}
 	return index
 }
 
 // Next advances the iterator to the next result that differs in the given node.
 // If nil is passed, the last node in the domain is used.
 func (iter *Iterator) Next(node rdf.Term) ([]rdf.Term, error) {
+	if iter.limited && iter.returned >= iter.limit {
+		return nil, nil
+	} else if err := iter.turn(false); err != nil {
+		return nil, err
+	}
+
+	d, err := iter.advance(node)
+	if d != nil {
+		iter.returned++
+	}
+	return d, err
+}
+
+// advance is Next without the query's limit
+func (iter *Iterator) advance(node rdf.Term) ([]rdf.Term, error) {
 	if iter.top || iter.empty {
 		return nil, nil
 	}
@@ -203,6 +233,17 @@ func (iter *Iterator) Next(node rdf.Term) ([]rdf.Term, error) {
 		return iter.Index(), nil
 	}
 
+	if iter.branches != nil {
+		return iter.nextUnion(node)
+	} else if len(iter.optionals) > 0 {
+		return iter.nextOptional(node)
+	}
+
+	return iter.nextRequired(node)
+}
+
+// nextRequired advances the variables of the query, not counting optional groups
+func (iter *Iterator) nextRequired(node rdf.Term) ([]rdf.Term, error) {
 	i := iter.pivot - 1
 	if node != nil {
 		value := node.String()
@@ -211,10 +252,12 @@ func (iter *Iterator) Next(node rdf.Term) ([]rdf.Term, error) {
 			i = index
 		}
 	} else if iter.pivot == 0 {
+		// Every solution is the same up to the pivot
+		iter.top = true
 		return nil, nil
 	}
 
-	tail, err := iter.next(i)
+	tail, err := iter.move(i)
 	if err != nil {
 		return nil, err
 	}
@@ -235,13 +278,27 @@ func (iter *Iterator) Next(node rdf.Term) ([]rdf.Term, error) {
 
 // Seek advances the iterator to the first result
 // greater than or equal to the given index path
-func (iter *Iterator) Seek(index []rdf.Term) (err error) {
+func (iter *Iterator) Seek(index []rdf.Term) error {
+	return iter.seekIndex(index, false)
+}
+
+// seekIndex turns the iterator in the given direction and seeks it to the index
+func (iter *Iterator) seekIndex(index []rdf.Term, reverse bool) (err error) {
 	if iter.empty {
 		return
 	}
 
+	if err = iter.turn(reverse); err != nil {
+		return
+	}
+
 	iter.bot = true
 	iter.top = false
+	iter.returned = 0
+
+	if iter.branches != nil {
+		return iter.seekUnion(index)
+	}
 
 	terms := make([]ID, len(index))
 	for i, node := range index {
@@ -251,32 +308,65 @@ func (iter *Iterator) Seek(index []rdf.Term) (err error) {
 		}
 	}
 
+	if iter.partitions != nil {
+		return iter.seekParallel(terms)
+	}
+
+	return iter.seek(terms)
+}
+
+// seek moves the variables to the first solution greater than
+// or equal to the given IDs, and sets iter.top if there isn't one.
+func (iter *Iterator) seek(terms []ID) (err error) {
 	l := iter.Len()
 	var ok bool
 
-	for _, u := range iter.variables {
-		u.value = u.root
-	}
-
+	// Each variable seeks to its term in the index for as long as
+	// every variable before it is still equal to its own term.
+	// Once a variable is raised past its root by the index, the variables
+	// before a variable without values aren't all at their first values,
+	// so tick can't be used to find the next one.
+	seeking, raised := true, false
+variables:
 	for i, u := range iter.variables {
-		var root ID
-		if i < len(terms) && u.root < terms[i] {
+		root := u.root
+		if seeking && i < len(terms) && u.before(root, terms[i]) {
 			root = terms[i]
-		} else if u.value == NIL {
-			root = u.root
 		}
 
-		if root != NIL {
-			for u.value = u.Seek(root); u.value == NIL; u.value = u.Seek(root) {
-				ok, err = iter.tick(i, 0, iter.cache)
-				if err != nil || !ok {
+		for u.value = u.Seek(root); u.value == NIL; u.value = u.Seek(root) {
+			if 0 < i && (raised || seeking && i < len(terms)) {
+				// None of the results start with the values of the variables
+				// before u, so the first result after the index is the next
+				// one that differs before u.
+				var tail int
+				if tail, err = iter.next(i - 1); err != nil {
 					return
-				}
-				if !ok {
+				} else if tail == l {
 					iter.top = true
 					return
 				}
+				break variables
+			}
+
+			ok, err = iter.tick(i, -1, iter.cache)
+			if err != nil {
+				return
+			} else if !ok {
+				iter.top = true
+				return
 			}
+			// tick advanced one of the previous variables,
+			// so u has to start over from its root
+			root, seeking = u.root, false
+		}
+
+		if root != u.root {
+			raised = true
+		}
+
+		if i >= len(terms) || u.value != terms[i] {
+			seeking = false
 		}
 
 		// We've got a non-nil value for u!
@@ -284,17 +374,14 @@ func (iter *Iterator) Seek(index []rdf.Term) (err error) {
 		if err != nil {
 			return err
 		}
-		for j, saved := range iter.cache[:i] {
-			if saved != nil {
-				iter.cache[j] = nil
-				if i+1 < l {
-					err = iter.push(iter.variables[j], i, l)
-					if err != nil {
-						return err
-					}
-				}
-			}
-		}
+
+		// iter.tick already pushed every variable that it changed
+		// through to the rest of the domain, so we just clear the cache.
+		clear(iter.cache[:i])
+	}
+
+	if len(iter.optionals) > 0 {
+		err = iter.setOptionals(0)
 	}
 
 	return
@@ -303,11 +390,11 @@ func (iter *Iterator) Seek(index []rdf.Term) (err error) {
 // Close the iterator
 func (iter *Iterator) Close() {
 	if iter != nil {
-		if iter.variables != nil {
-			for _, u := range iter.variables {
-				u.Close()
-			}
+		for _, p := range iter.partitions {
+			p.stop()
+			p.iter.Close()
 		}
+		iter.release()
 		if iter.txn != nil {
 			iter.txn.Discard()
 		}
@@ -317,7 +404,45 @@ func (iter *Iterator) Close() {
 	}
 }
 
+// release closes the iterator's constraints, but not its transaction or dictionary,
+// which iterators for optional groups share with the iterator of their query.
+func (iter *Iterator) release() {
+	if iter != nil {
+		for _, u := range iter.variables {
+			u.Close()
+		}
+		for _, o := range iter.optionals {
+			o.iter.release()
+			o.iter = nil
+		}
+		for _, branch := range iter.branches {
+			branch.release()
+		}
+	}
+}
+
+// difference returns the index of the first term that differs
+// between a and b, which is len(b) if a and b are the same.
+func difference(a, b []rdf.Term) int {
+	for i, term := range b {
+		if i >= len(a) {
+			return i
+		} else if (term == nil) != (a[i] == nil) || (term != nil && !term.Equal(a[i])) {
+			return i
+		}
+	}
+	return len(b)
+}
+
 func (iter *Iterator) String() string {
+	if iter.branches != nil {
+		var s string
+		for _, branch := range iter.branches {
+			s += branch.String()
+		}
+		return s
+	}
+
 	s := "----- Constraint Graph -----\n"
 	for i, id := range iter.domain {
 		s += fmt.Sprintf("---- %s ----\n%s\n", id, iter.variables[i].String())
@@ -326,49 +451,17 @@ func (iter *Iterator) String() string {
 	return s
 }
 
-// Sort interface functions
+// Len returns the number of variables and blank nodes in the query
 func (iter *Iterator) Len() int { return len(iter.domain) }
-func (iter *Iterator) Swap(a, b int) {
-	// Swap is very important in assembling the graph.
-	// The things it _does_ mutate are .variables and .domain.
-	// It does NOT mutate .ids or the constraints, which is how we
-	/// construct the transformation maps.
-	iter.variables[a], iter.variables[b] = iter.variables[b], iter.variables[a]
-	iter.domain[a], iter.domain[b] = iter.domain[b], iter.domain[a]
-}
 
-// TODO: put more thought into the sorting heuristic.
-// Right now the variables are sorted their norm: in
-// increasing order of their length-normalized sum of
-// the squares of the counts of all their constraints (of any degree).
-func (iter *Iterator) Less(a, b int) bool {
-	// So pivot right now is the length of the provided domain.
-	// We keep those in order...
-	if a < iter.pivot {
-		return a < b
-	} else if b < iter.pivot {
-		return false
-	}
-
-	A, B := iter.variables[a], iter.variables[b]
-	at, bt := A.node.TermType(), B.node.TermType()
-	if at == rdf.VariableType && bt == rdf.BlankNodeType {
-		return true
-	} else if at == rdf.BlankNodeType && bt == rdf.VariableType {
-		return false
-	}
-
-	return A.score < B.score
-}
-
-func (iter *Iterator) insertDZ(u *variable, c *constraint, txn *badger.Txn) (err error) {
+func (iter *Iterator) insertDZ(u *variable, c *constraint, txn view) (err error) {
 	if u.cs == nil {
 		u.cs = constraintSet{c}
 	} else {
 		u.cs = append(u.cs, c)
 	}
 
-	c.count, err = c.getCount(iter.unary, iter.binary, txn)
+	c.count, err = c.getCount(iter.statistics, iter.unary, iter.binary, txn)
 	if err != nil {
 		return
 	} else if c.count == 0 {
@@ -379,22 +472,19 @@ func (iter *Iterator) insertDZ(u *variable, c *constraint, txn *badger.Txn) (err
 	c.prefix = assembleKey(BinaryPrefixes[p], true, c.terms[p])
 
 	// Create a new badger.Iterator for the constraint
-	c.iterator = txn.NewIterator(badger.IteratorOptions{
-		PrefetchValues: false,
-		Prefix:         c.prefix,
-	})
+	c.open(txn, c.prefix)
 
 	return
 }
 
-func (iter *Iterator) insertD1(u *variable, c *constraint, txn *badger.Txn) (err error) {
+func (iter *Iterator) insertD1(u *variable, c *constraint, txn view) (err error) {
 	if u.cs == nil {
 		u.cs = constraintSet{c}
 	} else {
 		u.cs = append(u.cs, c)
 	}
 
-	c.count, err = c.getCount(iter.unary, iter.binary, txn)
+	c.count, err = c.getCount(iter.statistics, iter.unary, iter.binary, txn)
 	if err != nil {
 		return
 	} else if c.count == 0 {
@@ -406,15 +496,12 @@ func (iter *Iterator) insertD1(u *variable, c *constraint, txn *badger.Txn) (err
 	c.prefix = assembleKey(TernaryPrefixes[p], true, v, w)
 
 	// Create a new badger.Iterator for the constraint
-	c.iterator = txn.NewIterator(badger.IteratorOptions{
-		PrefetchValues: false,
-		Prefix:         c.prefix,
-	})
+	c.open(txn, c.prefix)
 
 	return
 }
 
-func (iter *Iterator) insertD2(u, v *variable, c *constraint, txn *badger.Txn) (err error) {
+func (iter *Iterator) insertD2(u, v *variable, c *constraint, txn view) (err error) {
 	// For second-degree constraints we get the *count* with an index key
 	// and set the *prefix* to either a major or minor key
 
@@ -435,7 +522,7 @@ func (iter *Iterator) insertD2(u, v *variable, c *constraint, txn *badger.Txn) (
 		u.cs = append(u.cs, c)
 	}
 
-	c.count, err = c.getCount(iter.unary, iter.binary, txn)
+	c.count, err = c.getCount(iter.statistics, iter.unary, iter.binary, txn)
 	if err != nil {
 		return
 	} else if c.count == 0 {
@@ -452,14 +539,136 @@ func (iter *Iterator) insertD2(u, v *variable, c *constraint, txn *badger.Txn) (
 	c.prefix = assembleKey(BinaryPrefixes[p], true, c.terms[p%3])
 
 	// Create a new badger.Iterator for the constraint
-	c.iterator = txn.NewIterator(badger.IteratorOptions{
-		PrefetchValues: false,
-		Prefix:         c.prefix,
-	})
+	c.open(txn, c.prefix)
+
+	return
+}
+
+func (iter *Iterator) insertD3(u, v, w *variable, c *constraint, txn view) (err error) {
+	// Third-degree constraints are outgoing constraints for both of the other
+	// variables in the triple, and they start out with no terms at all.
+	// Repeated variables only get one edge, and none to themselves.
+	if u.edges == nil {
+		u.edges = constraintMap{}
+	}
+
+	for k, neighbor := range []*variable{v, w} {
+		if neighbor == u || (k == 1 && w == v) {
+			continue
+		}
+
+		j := iter.getIndex(neighbor)
+		if cs, has := u.edges[j]; has {
+			u.edges[j] = append(cs, c)
+		} else {
+			u.edges[j] = constraintSet{c}
+		}
+	}
+
+	if u.cs == nil {
+		u.cs = constraintSet{c}
+	} else {
+		u.cs = append(u.cs, c)
+	}
+
+	c.count, err = c.getCount(iter.statistics, iter.unary, iter.binary, txn)
+	if err != nil {
+		return
+	} else if c.count == 0 {
+		return ErrEndOfSolutions
+	}
+
+	// With no other terms, the values for the constraint are every
+	// term in the unary index that occurs in the constraint's place.
+	c.prefix = []byte{UnaryPrefix}
+
+	// The prefix will change as the other variables get pushed into the
+	// constraint, so the iterator isn't restricted to any one index.
+	c.open(txn, nil)
 
 	return
 }
 
+func (iter *Iterator) insertGraph(g *variable, variables [3]*variable, c *constraint, txn view) (err error) {
+	// Graph constraints are outgoing constraints for every variable in the
+	// triple, and every constraint of the triple is an outgoing constraint
+	// for the graph, since the graph gets checked by whichever comes last.
+	if g.edges == nil {
+		g.edges = constraintMap{}
+	}
+
+	i := iter.getIndex(g)
+	for p, u := range variables {
+		if u == nil {
+			continue
+		}
+
+		j := iter.getIndex(u)
+		if cs, has := g.edges[j]; has {
+			g.edges[j] = append(cs, c)
+		} else {
+			g.edges[j] = constraintSet{c}
+		}
+
+		if u.edges == nil {
+			u.edges = constraintMap{}
+		}
+
+		if cs, has := u.edges[i]; has {
+			u.edges[i] = append(cs, c.neighbors[p])
+		} else {
+			u.edges[i] = constraintSet{c.neighbors[p]}
+		}
+	}
+
+	if g.cs == nil {
+		g.cs = constraintSet{c}
+	} else {
+		g.cs = append(g.cs, c)
+	}
+
+	err = c.setGraphs(iter.statistics, txn)
+	if err != nil {
+		return
+	} else if c.count == 0 {
+		return ErrEndOfSolutions
+	}
+
+	c.open(txn, []byte{GraphPrefix})
+
+	return
+}
+
+// filter returns a function that tests candidate values of u against its filters,
+// using the current values of the variables before it in the domain.
+func (iter *Iterator) filter(u *variable) func(ID) bool {
+	return func(value ID) bool {
+		get := func(node rdf.Term) rdf.Term {
+			id := value
+			if !node.Equal(u.node) {
+				id = iter.variables[iter.ids[node.String()]].value
+			}
+
+			if id == NIL {
+				return nil
+			}
+
+			term, err := iter.dictionary.GetTerm(id, rdf.Default)
+			if err != nil {
+				return nil
+			}
+			return term
+		}
+
+		for _, filter := range u.filters {
+			if result, _ := filter.test(get); !result {
+				return false
+			}
+		}
+		return true
+	}
+}
+
 func (iter *Iterator) getIndex(u *variable) int {
 	for i, v := range iter.variables {
 		if u == v {
@@ -0,0 +1,64 @@
+package styx
+
+// WithLimit limits the number of solutions that Next returns after the iterator
+// is created or seeked to n. A negative n means that there is no limit.
+// Solutions passed over with Skip don't count towards the limit.
+func WithLimit(n int) QueryOption {
+	return func(options *queryOptions) {
+		options.limited = n >= 0
+		options.limit = n
+	}
+}
+
+// Skip advances the iterator past the next n solutions that Next(nil) would
+// return, and returns the number of solutions that it skipped, which is only
+// less than n if there weren't that many left. Skip doesn't look up the values
+// of the solutions in the dictionary, except in queries with unions or optional
+// groups, whose solutions are compared by value.
+func (iter *Iterator) Skip(n int) (int, error) {
+	if iter.top || iter.empty || n <= 0 {
+		return 0, nil
+	} else if err := iter.turn(false); err != nil {
+		return 0, err
+	}
+
+	skipped := 0
+	if iter.branches != nil || len(iter.optionals) > 0 {
+		for ; skipped < n; skipped++ {
+			d, err := iter.advance(nil)
+			if err != nil {
+				return skipped, err
+			} else if d == nil {
+				break
+			}
+		}
+		return skipped, nil
+	}
+
+	// The current solution is skipped if it hasn't been returned by Next yet
+	if iter.bot {
+		iter.bot = false
+		skipped++
+	}
+
+	i := iter.pivot - 1
+	if i < 0 {
+		if skipped < n {
+			iter.top = true
+		}
+		return skipped, nil
+	}
+
+	l := iter.Len()
+	for ; skipped < n; skipped++ {
+		tail, err := iter.move(i)
+		if err != nil {
+			return skipped, err
+		} else if tail == l {
+			iter.top = true
+			break
+		}
+	}
+
+	return skipped, nil
+}
@@ -0,0 +1,159 @@
+package styx
+
+import (
+	"strings"
+
+	badger "github.com/dgraph-io/badger/v2"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// encodingVersion is the current version of the literal encoding.
+// Version 1 gives ordered literals IDs that sort by value.
+const encodingVersion = "1"
+
+// migrate re-encodes the literals in the indices and the quad store,
+// for databases that were written before literals were ordered by value.
+//
+// The index keys are moved in several transactions, so a migration that
+// fails partway leaves some of them in each encoding. That's safe, since
+// the version of the encoding only gets written at the end, and running
+// the migration again only converts the keys that are still in the old one.
+func migrate(db *badger.DB, config *Config) (err error) {
+	err = db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(EncodingKey)
+		return err
+	})
+	if err == nil {
+		return
+	} else if err != badger.ErrKeyNotFound {
+		return
+	}
+
+	// The dictionary only gets committed once everything else has worked.
+	// Dictionaries that can't discard their changes still have to be closed.
+	dictionary := config.Dictionary.Open(true)
+	committed := false
+	defer func() {
+		if committed {
+			return
+		} else if d, is := dictionary.(interface{ Discard() }); is {
+			d.Discard()
+		} else {
+			dictionary.Commit()
+		}
+	}()
+
+	// Literal IDs from the old encoding all start with a quote
+	convert := func(id ID) (ID, error) {
+		if len(id) == 0 || id[0] != '"' {
+			return id, nil
+		}
+		term, err := dictionary.GetTerm(id, rdf.Default)
+		if err != nil {
+			return NIL, err
+		}
+		return dictionary.GetID(term, rdf.Default)
+	}
+
+	prefixes := []byte{UnaryPrefix}
+	prefixes = append(prefixes, TernaryPrefixes[:]...)
+	prefixes = append(prefixes, BinaryPrefixes[:]...)
+
+	read := db.NewTransaction(false)
+	defer read.Discard()
+
+	txn := db.NewTransaction(true)
+	defer func() { txn.Discard() }()
+
+	for _, prefix := range prefixes {
+		iter := read.NewIterator(badger.IteratorOptions{Prefix: []byte{prefix}})
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			item := iter.Item()
+			key := item.KeyCopy(nil)
+
+			changed := false
+			terms := strings.Split(string(key[1:]), "\t")
+			ids := make([]ID, len(terms))
+			for i, term := range terms {
+				ids[i], err = convert(ID(term))
+				if err != nil {
+					iter.Close()
+					return
+				}
+				changed = changed || ids[i] != ID(term)
+			}
+
+			if !changed {
+				continue
+			}
+
+			var val []byte
+			val, err = item.ValueCopy(nil)
+			if err != nil {
+				iter.Close()
+				return
+			}
+
+			txn, err = setSafe(assembleKey(prefix, false, ids...), val, txn, db)
+			if err != nil {
+				iter.Close()
+				return
+			}
+
+			txn, err = deleteSafe(key, txn, db)
+			if err != nil {
+				iter.Close()
+				return
+			}
+		}
+		iter.Close()
+	}
+
+	// The datasets in the quad store have to be re-encoded too
+	list := config.QuadStore.List(NIL)
+	origins := []ID{}
+	for id, valid := list.Next(); valid; id, valid = list.Next() {
+		origins = append(origins, id)
+	}
+	list.Close()
+
+	for _, origin := range origins {
+		var quads [][4]ID
+		quads, err = config.QuadStore.Get(origin)
+		if err != nil {
+			return
+		}
+
+		changed := false
+		for j, quad := range quads {
+			for i, id := range quad {
+				quads[j][i], err = convert(id)
+				if err != nil {
+					return
+				}
+				changed = changed || quads[j][i] != id
+			}
+		}
+
+		if changed {
+			err = config.QuadStore.Set(origin, quads)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	// The dictionary has to have every new ID before the version gets written
+	committed = true
+	err = dictionary.Commit()
+	if err != nil {
+		return
+	}
+
+	txn, err = setSafe(EncodingKey, []byte(encodingVersion), txn, db)
+	if err != nil {
+		return
+	}
+
+	return txn.Commit()
+}
@@ -0,0 +1,194 @@
+package styx
+
+import (
+	badger "github.com/dgraph-io/badger/v2"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// A negation is a group of quads that eliminates the solutions of a query that it matches
+type negation struct {
+	pattern []*rdf.Quad
+	options *queryOptions
+	minus   bool
+}
+
+// WithNotExists adds a negated group to the query. A solution of the query is
+// eliminated if the group's pattern has a solution once the query's variables
+// are replaced with their values. Blank nodes in the group are local to the group,
+// and filters passed to the group can test both the group's and the query's variables.
+func WithNotExists(pattern []*rdf.Quad, options ...QueryOption) QueryOption {
+	return withNegation(pattern, options, false)
+}
+
+// WithMinus adds a group whose solutions are subtracted from the query's solutions.
+// It's the same as WithNotExists, except that a group that doesn't share any
+// variables with the query doesn't eliminate anything, and filters passed to
+// the group can only test the group's own variables and blank nodes.
+func WithMinus(pattern []*rdf.Quad, options ...QueryOption) QueryOption {
+	return withNegation(pattern, options, true)
+}
+
+func withNegation(pattern []*rdf.Quad, options []QueryOption, minus bool) QueryOption {
+	group := &queryOptions{}
+	for _, option := range options {
+		option(group)
+	}
+
+	return func(options *queryOptions) {
+		options.negations = append(options.negations, negation{pattern, group, minus})
+	}
+}
+
+// negate returns a filter on the query's variables that occur in the negation,
+// or nil if the negation can't eliminate any solutions.
+func (iter *Iterator) negate(n negation) (*Filter, error) {
+	terms := []rdf.Term{}
+	shared := map[string]bool{}
+	share := func(term rdf.Term) {
+		value := term.String()
+		if term.TermType() != rdf.VariableType || shared[value] {
+			return
+		} else if _, has := iter.ids[value]; has {
+			shared[value] = true
+			terms = append(terms, term)
+		}
+	}
+
+	for _, quad := range n.pattern {
+		for _, term := range quad {
+			share(term)
+		}
+	}
+
+	for _, path := range n.options.paths {
+		share(path.subject)
+		share(path.object)
+	}
+
+	for _, filter := range n.options.filters {
+		for _, term := range filter.terms {
+			if mentions(n.pattern, term) || mentionsPath(n.options.paths, term) {
+				continue
+			} else if !n.minus {
+				share(term)
+			}
+
+			if !shared[term.String()] {
+				return nil, ErrInvalidFilter
+			}
+		}
+	}
+
+	if n.minus && len(terms) == 0 {
+		return nil, nil
+	}
+
+	return &Filter{
+		terms: terms,
+		test: func(get func(rdf.Term) rdf.Term) (bool, bool) {
+			bindings := make(map[string]rdf.Term, len(terms))
+			for _, term := range terms {
+				value := get(term)
+				if value == nil {
+					return false, false
+				}
+				bindings[term.String()] = value
+			}
+
+			exists, err := iter.exists(n, bindings)
+			if err != nil {
+				return false, false
+			}
+			return !exists, true
+		},
+	}, nil
+}
+
+// exists checks whether a negation has a solution with the given values for its variables
+func (iter *Iterator) exists(n negation, bindings map[string]rdf.Term) (bool, error) {
+	pattern := substitute(n.pattern, bindings)
+	options := n.options.bind(bindings)
+	iter.inherit(options)
+
+	// Single triples can be looked up directly in the indices
+	plain := len(options.filters) == 0 && len(options.optionals) == 0 &&
+		len(options.unions) == 0 && len(options.negations) == 0 && len(options.paths) == 0 &&
+		options.source == nil
+	if plain && len(pattern) == 1 && pattern[0][3].TermType() == rdf.DefaultGraphType {
+		if ok, exists, err := iter.match(pattern[0]); ok || err != nil {
+			return exists, err
+		}
+	}
+
+	sub, err := newIterator(pattern, nil, nil, options, iter.tag, iter.planner, iter.txn, iter.dictionary)
+	defer sub.release()
+	if err == badger.ErrKeyNotFound || err == ErrEmptyInterset || err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	return !sub.empty && !sub.top, nil
+}
+
+// match checks whether a triple with at most two distinct variables or blank nodes
+// occurs in the database, using the same ternary, binary, and unary keys that
+// the constraints use. It returns ok = false if the triple has to be matched
+// by a full iterator instead.
+func (iter *Iterator) match(quad *rdf.Quad) (ok, exists bool, err error) {
+	var terms [3]ID
+	var free [3]bool
+	degree := 0
+	for p := 0; p < 3; p++ {
+		switch quad[p].TermType() {
+		case rdf.VariableType, rdf.BlankNodeType:
+			for q := 0; q < p; q++ {
+				if free[q] && quad[q].Equal(quad[p]) {
+					return false, false, nil
+				}
+			}
+			free[p] = true
+			degree++
+		default:
+			terms[p], err = iter.dictionary.GetID(quad[p], rdf.Default)
+			if err == ErrNotFound {
+				return true, false, nil
+			} else if err != nil {
+				return true, false, err
+			}
+		}
+	}
+
+	var count uint32
+	switch degree {
+	case 0:
+		_, err = iter.txn.Get(assembleKey(TernaryPrefixes[0], false, terms[:]...))
+		if err == badger.ErrKeyNotFound {
+			return true, false, nil
+		}
+		return true, err == nil, err
+	case 1:
+		// The binary key starting at the place after
+		// the variable counts the values of the variable
+		var p Permutation
+		for ; p < 3; p++ {
+			if free[p] {
+				break
+			}
+		}
+		a, b := (p+1)%3, (p+2)%3
+		count, err = iter.binary.Get(a, terms[a], terms[b], iter.txn)
+	case 2:
+		var p Permutation
+		for ; p < 3; p++ {
+			if !free[p] {
+				break
+			}
+		}
+		count, err = iter.unary.Get(p, terms[p], iter.txn)
+	default:
+		return false, false, nil
+	}
+
+	return true, count > 0, err
+}
@@ -0,0 +1,226 @@
+package styx
+
+import (
+	badger "github.com/dgraph-io/badger/v2"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// An optionalPattern is a group of quads that extends the solutions
+// of a query when it matches, and leaves them alone when it doesn't.
+type optionalPattern struct {
+	pattern []*rdf.Quad
+	options *queryOptions
+}
+
+// WithOptional adds an optional group to the query. Each solution of the query
+// is joined with the solutions of the group's pattern, if it has any, and is
+// otherwise returned with the group's variables unbound. Groups are joined in
+// order, so later groups see the values that earlier groups bound.
+// Blank nodes in the group are local to the group, and filters on the group's
+// variables have to be passed to the group, not to the query.
+func WithOptional(pattern []*rdf.Quad, options ...QueryOption) QueryOption {
+	group := &queryOptions{}
+	for _, option := range options {
+		option(group)
+	}
+
+	return func(options *queryOptions) {
+		options.optionals = append(options.optionals, optionalPattern{pattern, group})
+	}
+}
+
+// bind substitutes constant values for the given variables
+func (options *queryOptions) bind(bindings map[string]rdf.Term) *queryOptions {
+	result := &queryOptions{
+		filters:   make([]*Filter, len(options.filters)),
+		optionals: make([]optionalPattern, len(options.optionals)),
+		unions:    make([][][]*rdf.Quad, len(options.unions)),
+		negations: make([]negation, len(options.negations)),
+		paths:     make([]*Path, len(options.paths)),
+	}
+
+	for i, filter := range options.filters {
+		result.filters[i] = filter.bind(bindings)
+	}
+
+	for i, group := range options.optionals {
+		result.optionals[i] = optionalPattern{
+			pattern: substitute(group.pattern, bindings),
+			options: group.options.bind(bindings),
+		}
+	}
+
+	for i, union := range options.unions {
+		result.unions[i] = make([][]*rdf.Quad, len(union))
+		for j, pattern := range union {
+			result.unions[i][j] = substitute(pattern, bindings)
+		}
+	}
+
+	for i, n := range options.negations {
+		result.negations[i] = negation{
+			pattern: substitute(n.pattern, bindings),
+			options: n.options.bind(bindings),
+			minus:   n.minus,
+		}
+	}
+
+	for i, path := range options.paths {
+		result.paths[i] = path.bind(bindings)
+	}
+
+	return result
+}
+
+func substitute(pattern []*rdf.Quad, bindings map[string]rdf.Term) []*rdf.Quad {
+	result := make([]*rdf.Quad, len(pattern))
+	for i, quad := range pattern {
+		terms := [4]rdf.Term{}
+		for p, term := range quad {
+			terms[p] = term
+			if term.TermType() == rdf.VariableType {
+				if value, has := bindings[term.String()]; has {
+					terms[p] = value
+				}
+			}
+		}
+		result[i] = rdf.NewQuad(terms[0], terms[1], terms[2], terms[3])
+	}
+	return result
+}
+
+// An optional is an optional group's state within an iterator
+type optional struct {
+	optionalPattern
+	iter *Iterator // The solutions of the group, or nil if it doesn't match
+}
+
+// getOptional returns the value of an optional variable, or nil if it's unbound
+func (iter *Iterator) getOptional(node rdf.Term) rdf.Term {
+	for _, o := range iter.optionals {
+		if o.iter != nil {
+			if _, has := o.iter.ids[node.String()]; has {
+				return o.iter.Get(node)
+			}
+		}
+	}
+	return nil
+}
+
+// setOptionals re-evaluates the optional groups from j on,
+// using the current values of the query and the groups before j.
+func (iter *Iterator) setOptionals(j int) (err error) {
+	for _, o := range iter.optionals[j:] {
+		o.iter.release()
+		o.iter = nil
+	}
+
+	for _, o := range iter.optionals[j:] {
+		bindings := make(map[string]rdf.Term, iter.pivot)
+		for _, node := range iter.domain[:iter.pivot] {
+			bindings[node.String()] = iter.Get(node)
+		}
+		for _, node := range iter.optionalDomain {
+			if value := iter.getOptional(node); value != nil {
+				bindings[node.String()] = value
+			}
+		}
+
+		pattern := substitute(o.pattern, bindings)
+		options := o.options.bind(bindings)
+		iter.inherit(options)
+		o.iter, err = newIterator(pattern, nil, nil, options, iter.tag, iter.planner, iter.txn, iter.dictionary)
+		if err == badger.ErrKeyNotFound || err == ErrEmptyInterset || err == ErrNotFound {
+			o.iter.release()
+			o.iter, err = nil, nil
+			continue
+		} else if err != nil {
+			o.iter.release()
+			o.iter = nil
+			return
+		}
+
+		if o.iter.empty || o.iter.top {
+			o.iter.release()
+			o.iter = nil
+		} else {
+			// The group's iterator is already at its first solution
+			o.iter.bot = false
+		}
+	}
+
+	return
+}
+
+// nextOptional advances the iterator when it has optional groups. The groups
+// are advanced like the digits of an odometer, after the required variables.
+func (iter *Iterator) nextOptional(node rdf.Term) ([]rdf.Term, error) {
+	previous := iter.Index()
+
+	var required bool
+	if node != nil {
+		_, required = iter.ids[node.String()]
+	}
+
+	advanced := false
+	if !required {
+		start := len(iter.optionals) - 1
+		if node != nil {
+			for j, o := range iter.optionals {
+				if mentions(o.pattern, node) || mentionsPath(o.options.paths, node) {
+					start = j
+					break
+				}
+			}
+		}
+
+		for j := start; j >= 0 && !advanced; j-- {
+			sub := iter.optionals[j].iter
+			if sub == nil {
+				continue
+			}
+
+			var next rdf.Term
+			if node != nil && j == start {
+				if _, has := sub.ids[node.String()]; has {
+					next = node
+				}
+			}
+
+			d, err := sub.Next(next)
+			if err != nil {
+				return nil, err
+			} else if d != nil {
+				advanced = true
+				if err = iter.setOptionals(j + 1); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		node = nil
+	}
+
+	if !advanced {
+		d, err := iter.nextRequired(node)
+		if err != nil || d == nil {
+			return nil, err
+		} else if err = iter.setOptionals(0); err != nil {
+			return nil, err
+		}
+	}
+
+	index := iter.Index()
+	return index[difference(previous, index):], nil
+}
+
+func mentions(pattern []*rdf.Quad, node rdf.Term) bool {
+	for _, quad := range pattern {
+		for _, term := range quad {
+			if term.Equal(node) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,320 @@
+package styx
+
+import (
+	badger "github.com/dgraph-io/badger/v2"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// partitionBuffer is the number of solutions that each partition
+// evaluates ahead of the solution that the iterator is at.
+const partitionBuffer = 256
+
+// WithParallel evaluates the query in up to n goroutines. The values of the first
+// variable in the domain are split into n ranges of about the same size, and each
+// goroutine evaluates the solutions in one range with its own iterator and badger
+// read transaction. The solutions are merged back in the same order as the query
+// would have them otherwise, so Next, Seek, and Skip work just the same.
+// Queries with unions or optional groups are always evaluated sequentially.
+func WithParallel(n int) QueryOption {
+	return func(options *queryOptions) {
+		options.parallel = n
+	}
+}
+
+// A partition is the range of values [lower, upper) of the first variable
+// of a parallel iterator, and the iterator that evaluates its solutions.
+// The last partition has no upper bound.
+type partition struct {
+	iter      *Iterator
+	lower     ID
+	upper     ID
+	solutions chan solution
+	done      chan struct{}
+}
+
+// A solution is the values of an iterator's variables, or
+// the error that the partition's goroutine stopped with.
+type solution struct {
+	values []ID
+	err    error
+}
+
+// parallelize splits the values of the iterator's first variable into up to n
+// ranges and opens an iterator over the query for each of them, each with its
+// own transaction and dictionary from open. Iterators that can't be split are
+// left alone.
+func (iter *Iterator) parallelize(
+	query []*rdf.Quad,
+	index []rdf.Term,
+	n int,
+	options *queryOptions,
+	open func() (view, Dictionary),
+) (err error) {
+	if iter.empty || iter.top || iter.branches != nil || len(iter.optionals) > 0 || iter.pivot == 0 {
+		return
+	}
+
+	bounds := iter.bounds(n)
+	if len(bounds) == 0 {
+		return iter.Seek(index)
+	}
+
+	// The partitions get the iterator's whole domain, so that they
+	// order their variables the same way as the iterator does.
+	domain := make([]rdf.Term, iter.Len())
+	copy(domain, iter.domain)
+
+	partitions := make([]*partition, 0, len(bounds)+1)
+	lower := NIL
+	for i := 0; i <= len(bounds); i++ {
+		upper := NIL
+		if i < len(bounds) {
+			upper = bounds[i]
+		}
+
+		txn, dictionary := open()
+		var p *Iterator
+		p, err = newIterator(query, domain, nil, options, iter.tag, iter.planner, txn, dictionary)
+		if err == badger.ErrKeyNotFound || err == ErrEmptyInterset || err == ErrNotFound {
+			// The partition's transaction doesn't see any solutions at all
+			p.Close()
+			err = nil
+		} else if err != nil || p.Len() != iter.Len() {
+			// If the partitions can't use the iterator's domain,
+			// then the query is evaluated sequentially.
+			p.Close()
+			for _, p := range partitions {
+				p.iter.Close()
+			}
+			if err == ErrInvalidDomain {
+				err = nil
+			}
+			if err != nil {
+				return
+			}
+			return iter.Seek(index)
+		} else if p.restrict(lower, upper) {
+			partitions = append(partitions, &partition{iter: p, lower: lower, upper: upper})
+		} else {
+			p.Close()
+		}
+
+		lower = upper
+	}
+
+	iter.partitions = partitions
+	return iter.Seek(index)
+}
+
+// bounds returns the values of the first variable that start every range after
+// the first one, out of up to n ranges. Like the planner's samples, they're spread
+// evenly between the first variable's first and last IDs, so that they only take
+// a few seeks instead of a scan over all of its values. bounds only intersects
+// the first variable's constraints, which moves them away from the iterator's
+// current solution.
+func (iter *Iterator) bounds(n int) []ID {
+	u := iter.variables[0]
+	first := u.Seek(u.root)
+	if first == NIL {
+		return nil
+	}
+
+	// The first variable's values end at the smallest last value of its constraints
+	e := newEstimator(iter)
+	last := NIL
+	for _, c := range u.cs {
+		if value := e.last(c); value != NIL && (last == NIL || value < last) {
+			last = value
+		}
+	}
+
+	if last <= first {
+		return nil
+	}
+
+	bounds := make([]ID, 0, n-1)
+	for k := 1; k < n; k++ {
+		value := u.Seek(step(first, last, k, n))
+		if value == NIL {
+			break
+		} else if value <= first || (len(bounds) > 0 && value <= bounds[len(bounds)-1]) {
+			continue
+		}
+		bounds = append(bounds, value)
+	}
+
+	return bounds
+}
+
+// restrict limits the values of the iterator's first variable to [lower, upper),
+// and returns false if the first variable doesn't have any values in the range.
+func (iter *Iterator) restrict(lower, upper ID) bool {
+	if iter.empty || iter.top {
+		return false
+	}
+
+	u := iter.variables[0]
+	if upper != NIL && (u.upper == NIL || upper < u.upper) {
+		u.upper = upper
+	}
+
+	if lower > u.root {
+		u.root = lower
+	}
+
+	u.root = u.Seek(u.root)
+	return u.root != NIL
+}
+
+// start evaluates the partition's solutions starting
+// at the given IDs in a new goroutine.
+func (p *partition) start(terms []ID) {
+	p.solutions = make(chan solution, partitionBuffer)
+	p.done = make(chan struct{})
+	go p.run(terms, p.solutions, p.done)
+}
+
+func (p *partition) run(terms []ID, solutions chan<- solution, done <-chan struct{}) {
+	defer close(solutions)
+
+	iter := p.iter
+	iter.top = false
+	err := iter.seek(terms)
+
+	l := iter.Len()
+	for err == nil && !iter.top {
+		values := make([]ID, l)
+		for i, u := range iter.variables {
+			values[i] = u.value
+		}
+
+		select {
+		case solutions <- solution{values: values}:
+		case <-done:
+			return
+		}
+
+		var tail int
+		tail, err = iter.next(iter.pivot - 1)
+		if err == nil && tail == l {
+			iter.top = true
+		}
+	}
+
+	if err != nil {
+		select {
+		case solutions <- solution{err: err}:
+		case <-done:
+		}
+	}
+}
+
+// stop waits for the partition's goroutine to return, if it's running
+func (p *partition) stop() {
+	if p.solutions != nil {
+		close(p.done)
+		for range p.solutions {
+		}
+		p.solutions, p.done = nil, nil
+	}
+}
+
+// seekParallel restarts the partitions at the given IDs,
+// and moves the iterator to the first of their solutions.
+func (iter *Iterator) seekParallel(terms []ID) error {
+	for _, p := range iter.partitions {
+		p.stop()
+	}
+
+	for _, p := range iter.partitions {
+		p.start(terms)
+	}
+
+	iter.partition = 0
+	values, err := iter.receive()
+	if err != nil {
+		return err
+	} else if values == nil {
+		iter.top = true
+		return nil
+	}
+
+	for i, u := range iter.variables {
+		u.value = values[i]
+	}
+	return nil
+}
+
+// receive returns the next solution of the partitions in order,
+// or nil if every partition has run out of solutions.
+func (iter *Iterator) receive() ([]ID, error) {
+	for iter.partition < len(iter.partitions) {
+		s, ok := <-iter.partitions[iter.partition].solutions
+		if !ok {
+			iter.partition++
+		} else if s.err != nil {
+			return nil, s.err
+		} else {
+			return s.values, nil
+		}
+	}
+	return nil, nil
+}
+
+// move advances the iterator to the next solution that differs in one of the
+// variables up to and including i, and returns the index of the first variable
+// that changed, which is iter.Len() if there are no more solutions.
+// The solutions of parallel iterators come from their partitions,
+// and the rest of the iterators advance their own variables.
+func (iter *Iterator) move(i int) (int, error) {
+	if iter.partitions == nil {
+		return iter.next(i)
+	}
+
+	l := iter.Len()
+	for {
+		values, err := iter.receive()
+		if err != nil {
+			return l, err
+		} else if values == nil {
+			return l, nil
+		}
+
+		tail := 0
+		for tail < l && values[tail] == iter.variables[tail].value {
+			tail++
+		}
+
+		if tail <= i {
+			for j, u := range iter.variables[tail:] {
+				u.value = values[tail+j]
+			}
+			return tail, nil
+		}
+	}
+}
+
+// seekSolution seeks the variables of a parallel iterator to the solution
+// that it got from its partitions, so that its constraints are at the solution.
+func (iter *Iterator) seekSolution() error {
+	values := make([]ID, len(iter.variables))
+	for i, u := range iter.variables {
+		values[i] = u.value
+	}
+	return iter.seek(values)
+}
+
+// sequential stops the partitions of a parallel iterator, and seeks
+// its own variables to its current solution to go on without them.
+func (iter *Iterator) sequential() error {
+	for _, p := range iter.partitions {
+		p.stop()
+		p.iter.Close()
+	}
+
+	iter.partitions = nil
+	if iter.top {
+		return nil
+	}
+	return iter.seekSolution()
+}
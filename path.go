@@ -0,0 +1,232 @@
+package styx
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	badger "github.com/dgraph-io/badger/v2"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// A Path is a chain of triples with the same predicate that leads from
+// its subject to its object, like schema:knows+ or rdfs:subClassOf*.
+// Paths match triples in any graph.
+type Path struct {
+	subject   rdf.Term
+	predicate rdf.Term
+	object    rdf.Term
+	min       int // The least number of triples in the chain
+}
+
+// OneOrMore returns a path of one or more triples from the subject to the object
+func OneOrMore(subject, predicate, object rdf.Term) *Path {
+	return &Path{subject, predicate, object, 1}
+}
+
+// ZeroOrMore returns a path of zero or more triples from the subject to the object.
+// Paths of length zero match every term in the database with itself.
+func ZeroOrMore(subject, predicate, object rdf.Term) *Path {
+	return &Path{subject, predicate, object, 0}
+}
+
+func (path *Path) modifier() string {
+	if path.min == 0 {
+		return "*"
+	}
+	return "+"
+}
+
+func (path *Path) String() string {
+	return fmt.Sprintf("%s %s%s %s", path.subject, path.predicate, path.modifier(), path.object)
+}
+
+// WithPaths adds paths to the query. The subjects and objects of the paths
+// are joined with the rest of the query like the terms of any other triple.
+func WithPaths(paths ...*Path) QueryOption {
+	return func(options *queryOptions) {
+		options.paths = append(options.paths, paths...)
+	}
+}
+
+// bind returns a path that uses the given values for its variables
+func (path *Path) bind(bindings map[string]rdf.Term) *Path {
+	quad := substitute([]*rdf.Quad{rdf.NewQuad(path.subject, path.predicate, path.object, nil)}, bindings)[0]
+	return &Path{quad[0], quad[1], quad[2], path.min}
+}
+
+func mentionsPath(paths []*Path, node rdf.Term) bool {
+	for _, path := range paths {
+		if path.subject.Equal(node) || path.object.Equal(node) {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePath inserts the constraints for one of the query's paths
+func (iter *Iterator) parsePath(i int, path *Path, txn view) (err error) {
+	if path.predicate.TermType() != rdf.NamedNodeType {
+		return fmt.Errorf("Invalid path predicate: %d", i)
+	}
+
+	quad := rdf.NewQuad(path.subject, path.predicate, path.object, nil)
+	variables := [3]*variable{iter.parseNode(path.subject), nil, iter.parseNode(path.object)}
+	if variables[0] != nil && variables[0] == variables[2] {
+		return fmt.Errorf("Cannot handle repeated blank nodes in path: %d", i)
+	}
+
+	terms := [3]ID{}
+	for p := 0; p < 3; p++ {
+		if variables[p] == nil {
+			terms[p], err = iter.dictionary.GetID(quad[p], rdf.Default)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	if variables[0] == nil && variables[2] == nil {
+		// Paths between two constants are checked right away
+		c := &constraint{place: 2, quad: quad, terms: terms, txn: txn, path: path, sources: iter.sources}
+		if err = c.setPath(txn); err != nil {
+			return
+		} else if c.Seek(terms[2]) != terms[2] {
+			return ErrEndOfSolutions
+		}
+		return
+	}
+
+	// The neighbors of a path's constraints are indexed by place,
+	// like the neighbors of a triple's constraints.
+	neighbors := make([]*constraint, 3)
+	for _, p := range []Permutation{0, 2} {
+		if variables[p] != nil {
+			neighbors[p] = &constraint{place: p, quad: quad, terms: terms, txn: txn, neighbors: neighbors, path: path, sources: iter.sources}
+		}
+	}
+
+	for _, p := range []Permutation{0, 2} {
+		if u := variables[p]; u != nil {
+			err = iter.insertPath(u, variables[2-p], neighbors[p], txn)
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	return
+}
+
+func (iter *Iterator) insertPath(u, v *variable, c *constraint, txn view) (err error) {
+	// Path constraints are outgoing constraints for the variable at the
+	// other end of the path, which pushes the nodes it reaches into them.
+	if v != nil {
+		if u.edges == nil {
+			u.edges = constraintMap{}
+		}
+
+		j := iter.getIndex(v)
+		if cs, has := u.edges[j]; has {
+			u.edges[j] = append(cs, c)
+		} else {
+			u.edges[j] = constraintSet{c}
+		}
+	}
+
+	if u.cs == nil {
+		u.cs = constraintSet{c}
+	} else {
+		u.cs = append(u.cs, c)
+	}
+
+	if c.fixed() {
+		err = c.setPath(txn)
+	} else if c.path.min == 0 {
+		// With nothing at the other end, the values of a path of length zero
+		// or more are every subject and object in the unary index.
+		c.count = iter.statistics.terms[0] + iter.statistics.terms[2]
+		c.prefix = []byte{UnaryPrefix}
+	} else {
+		// With nothing at the other end, the values of a path of length one
+		// or more are the subjects or objects of the path's predicate.
+		var p Permutation = 1
+		if c.place == 0 {
+			p = 4
+		}
+		c.prefix = assembleKey(BinaryPrefixes[p], true, c.terms[1])
+		c.count, err = iter.unary.Get(p, c.terms[1], txn)
+	}
+
+	if err != nil {
+		return
+	} else if c.count == 0 {
+		return ErrEndOfSolutions
+	}
+
+	if !c.fixed() {
+		c.open(txn, c.prefix)
+	}
+
+	return
+}
+
+// setPath sets the values of a path constraint to the nodes that are reachable
+// from the node at the other end of the path, walking the ternary index
+// breadth-first and visiting each node only once.
+func (c *constraint) setPath(txn view) error {
+	other := 2 - c.place
+	A, B := (c.place+1)%3, (c.place+2)%3
+	iterator := txn.NewIterator(badger.IteratorOptions{
+		PrefetchValues: false,
+		Prefix:         []byte{TernaryPrefixes[A]},
+	})
+	defer iterator.Close()
+
+	visited := map[ID]bool{}
+	c.values = c.values[:0]
+	if c.path.min == 0 {
+		visited[c.terms[other]] = true
+		c.values = append(c.values, c.terms[other])
+	}
+
+	terms := c.terms
+	for queue := []ID{c.terms[other]}; len(queue) > 0; queue = queue[1:] {
+		terms[other] = queue[0]
+		prefix := assembleKey(TernaryPrefixes[A], true, terms[A], terms[B])
+		for iterator.Seek(prefix); iterator.ValidForPrefix(prefix); iterator.Next() {
+			key := iterator.Item().Key()
+			v := ID(key[bytes.LastIndexByte(key, '\t')+1:])
+			if !visited[v] && c.traverses(iterator.Item()) {
+				visited[v] = true
+				c.values = append(c.values, v)
+				queue = append(queue, v)
+			}
+		}
+	}
+
+	sort.Slice(c.values, func(i, j int) bool { return c.values[i] < c.values[j] })
+	c.cursor = 0
+	c.count = uint32(len(c.values))
+	return nil
+}
+
+// traverses returns true if the path can follow the edge of the ternary index
+// item, which is when one of the edge's statements is in the path's datasets
+func (c *constraint) traverses(item viewItem) (ok bool) {
+	if c.sources == nil {
+		return true
+	}
+
+	_ = item.Value(func(val []byte) error {
+		statements, err := getStatements(val)
+		for _, statement := range statements {
+			if c.sources.accepts(statement) {
+				ok = true
+			}
+		}
+		return err
+	})
+
+	return
+}
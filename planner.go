@@ -0,0 +1,341 @@
+package styx
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+
+	badger "github.com/dgraph-io/badger/v2"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// A Planner decides the order that an iterator assigns values to the variables
+// of a query. The variables in the query's domain always come first, in the
+// order that they were given, so the planner only orders the rest of them.
+// Plan returns a permutation of the indices of the candidates. The order is kept
+// except that variables always come before blank nodes, which aren't part of
+// the solutions that Next returns.
+type Planner interface {
+	Plan(domain []rdf.Term, candidates []*Candidate, estimator Estimator) ([]int, error)
+}
+
+// A Candidate is a variable whose place in the order is up to the planner
+type Candidate struct {
+	Node        rdf.Term
+	Norm        uint64  // The sum of squares of the counts of its constraints
+	Score       float64 // The norm divided by the number of constraints
+	Constraints []*ConstraintPlan
+}
+
+// An Estimator estimates how many values a variable has once another
+// variable has a value. Estimates are read from the binary and unary
+// indices for a sample of the values of the source variable.
+type Estimator interface {
+	Fanout(source, target rdf.Term) (float64, error)
+}
+
+// CostPlanner is the default planner. It orders the variables greedily, always
+// choosing the variable with the fewest estimated values given the variables
+// before it, which is the smallest count of its constraints or the smallest
+// fanout from any of the variables that come before it.
+var CostPlanner Planner = costPlanner{}
+
+// ScorePlanner orders the variables by their score
+var ScorePlanner Planner = scorePlanner{}
+
+// ErrInvalidPlan means that a planner didn't return a permutation of its candidates
+var ErrInvalidPlan = errors.New("Invalid plan")
+
+// plannerSamples is the number of values of the source that fanouts are averaged over
+const plannerSamples = 16
+
+type costPlanner struct{}
+
+func (cp costPlanner) Plan(domain []rdf.Term, candidates []*Candidate, estimator Estimator) ([]int, error) {
+	order := make([]int, 0, len(candidates))
+	nodes := append([]rdf.Term{}, domain...)
+	used := make([]bool, len(candidates))
+	for len(order) < len(candidates) {
+		// Blank nodes can't be chosen until all the variables are
+		variables := false
+		for i, c := range candidates {
+			if !used[i] && c.Node.TermType() == rdf.VariableType {
+				variables = true
+			}
+		}
+
+		best, estimate := -1, 0.0
+		for i, c := range candidates {
+			if used[i] || variables && c.Node.TermType() != rdf.VariableType {
+				continue
+			}
+
+			e := math.Inf(1)
+			for _, constraint := range c.Constraints {
+				if count := float64(constraint.Count); count < e {
+					e = count
+				}
+			}
+
+			for _, node := range nodes {
+				fanout, err := estimator.Fanout(node, c.Node)
+				if err != nil {
+					return nil, err
+				} else if fanout < e {
+					e = fanout
+				}
+			}
+
+			if best == -1 || e < estimate || e == estimate && c.Score < candidates[best].Score {
+				best, estimate = i, e
+			}
+		}
+
+		used[best] = true
+		order = append(order, best)
+		nodes = append(nodes, candidates[best].Node)
+	}
+
+	return order, nil
+}
+
+type scorePlanner struct{}
+
+// Plan sorts the variables in increasing order of their length-normalized
+// sum of the squares of the counts of all their constraints (of any degree).
+func (sp scorePlanner) Plan(domain []rdf.Term, candidates []*Candidate, estimator Estimator) ([]int, error) {
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(a, b int) bool {
+		return candidates[order[a]].Score < candidates[order[b]].Score
+	})
+
+	return order, nil
+}
+
+// estimator estimates fanouts from the constraint graph before it's sorted
+type estimator struct {
+	iter    *Iterator
+	fanouts map[[2]int]float64
+}
+
+func newEstimator(iter *Iterator) *estimator {
+	return &estimator{iter: iter, fanouts: map[[2]int]float64{}}
+}
+
+// Fanout returns the smallest average number of values that the target has
+// for a sample of the values of the source, over all the triples that they
+// share. Without any triples in common, the target's values don't depend on
+// the source, and the fanout is the smallest count of the target's constraints.
+func (e *estimator) Fanout(source, target rdf.Term) (float64, error) {
+	i, has := e.iter.ids[source.String()]
+	if !has {
+		return 0, ErrInvalidDomain
+	}
+
+	j, has := e.iter.ids[target.String()]
+	if !has {
+		return 0, ErrInvalidDomain
+	}
+
+	if fanout, has := e.fanouts[[2]int{i, j}]; has {
+		return fanout, nil
+	}
+
+	fanout := math.Inf(1)
+	for _, c := range e.iter.variables[j].cs {
+		if count := float64(c.count); count < fanout {
+			fanout = count
+		}
+
+		if c.place == 3 || c.path != nil || len(c.neighbors) < 3 {
+			continue
+		}
+
+		for q := Permutation(0); q < 3; q++ {
+			n := c.neighbors[q]
+			if q == c.place || n == nil || n.place != q || !c.quad[q].Equal(source) {
+				continue
+			}
+
+			f, err := e.sample(c, n)
+			if err != nil {
+				return 0, err
+			} else if f < fanout {
+				fanout = f
+			}
+		}
+	}
+
+	e.fanouts[[2]int{i, j}] = fanout
+	return fanout, nil
+}
+
+// sample averages the counts of c for values of its neighbor n, using a copy
+// of n so that the constraint graph isn't moved. When n has more values than
+// plannerSamples, they're spread evenly over the range of n's IDs, since the
+// first IDs are the first terms that were inserted and aren't representative.
+func (e *estimator) sample(c, n *constraint) (float64, error) {
+	s := *n
+	s.reverse = false
+
+	var values []ID
+	if s.fixed() {
+		values = s.values
+		if len(values) > plannerSamples {
+			values = make([]ID, plannerSamples)
+			for k := range values {
+				values[k] = s.values[k*len(s.values)/plannerSamples]
+			}
+		}
+	} else {
+		values = e.spread(&s)
+	}
+
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	var total float64
+	for _, value := range values {
+		t := *c
+		t.terms[n.place] = value
+		count, err := t.getCount(e.iter.statistics, e.iter.unary, e.iter.binary, e.iter.txn)
+		if err != nil {
+			return 0, err
+		}
+		total += float64(count)
+	}
+
+	return total / float64(len(values)), nil
+}
+
+// spread returns up to plannerSamples values of s by seeking to IDs
+// at even steps between its first and last values
+func (e *estimator) spread(s *constraint) []ID {
+	s.iterator = e.iter.txn.NewIterator(badger.IteratorOptions{
+		PrefetchValues: false,
+		Prefix:         s.prefix,
+	})
+	defer s.iterator.Close()
+
+	values := make([]ID, 0, plannerSamples)
+	for value := s.Seek(NIL); value != NIL; value = s.Next() {
+		if len(values) == plannerSamples {
+			break
+		}
+		values = append(values, value)
+	}
+
+	if len(values) < plannerSamples {
+		return values
+	}
+
+	first := values[0]
+	last := e.last(s)
+
+	values = values[:0]
+	for k := 0; k < plannerSamples; k++ {
+		value := s.Seek(step(first, last, k, plannerSamples))
+		if value == NIL {
+			break
+		} else if len(values) > 0 && value <= values[len(values)-1] {
+			continue
+		}
+		values = append(values, value)
+	}
+
+	return values
+}
+
+// last returns the last value of s with a reversed iterator
+func (e *estimator) last(s *constraint) ID {
+	r := *s
+	r.reverse = true
+	r.iterator = e.iter.txn.NewIterator(badger.IteratorOptions{
+		PrefetchValues: false,
+		Prefix:         s.prefix,
+		Reverse:        true,
+	})
+	defer r.iterator.Close()
+	return r.Seek(NIL)
+}
+
+// step returns the ID that is k/n of the way from a to b, reading the
+// eight bytes after their common prefix as a big-endian integer
+func step(a, b ID, k, n int) ID {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+
+	var x, y uint64
+	for j := i; j < i+8; j++ {
+		x, y = x<<8, y<<8
+		if j < len(a) {
+			x |= uint64(a[j])
+		}
+		if j < len(b) {
+			y |= uint64(b[j])
+		}
+	}
+
+	tail := make([]byte, 8)
+	binary.BigEndian.PutUint64(tail, x+(y-x)/uint64(n)*uint64(k))
+	return a[:i] + ID(bytes.TrimRight(tail, "\x00"))
+}
+
+// reorder orders the variables after the domain with the iterator's planner
+func (iter *Iterator) reorder() error {
+	candidates := make([]*Candidate, len(iter.variables)-iter.pivot)
+	for i, u := range iter.variables[iter.pivot:] {
+		candidates[i] = &Candidate{
+			Node:        u.node,
+			Norm:        u.norm,
+			Score:       u.score,
+			Constraints: constraintPlans(u.cs),
+		}
+	}
+
+	planner := iter.planner
+	if planner == nil {
+		planner = CostPlanner
+	}
+
+	order, err := planner.Plan(iter.domain[:iter.pivot], candidates, newEstimator(iter))
+	if err != nil {
+		return err
+	} else if len(order) != len(candidates) {
+		return ErrInvalidPlan
+	}
+
+	seen := make([]bool, len(candidates))
+	for _, i := range order {
+		if i < 0 || i >= len(candidates) || seen[i] {
+			return ErrInvalidPlan
+		}
+		seen[i] = true
+	}
+
+	// Variables come before blank nodes and hidden variables,
+	// and otherwise the order is the planner's
+	sort.SliceStable(order, func(a, b int) bool {
+		A, B := candidates[order[a]].Node, candidates[order[b]].Node
+		return !iter.existential(A) && iter.existential(B)
+	})
+
+	variables := make([]*variable, len(order))
+	domain := make([]rdf.Term, len(order))
+	for j, i := range order {
+		variables[j] = iter.variables[iter.pivot+i]
+		domain[j] = iter.domain[iter.pivot+i]
+	}
+	copy(iter.variables[iter.pivot:], variables)
+	copy(iter.domain[iter.pivot:], domain)
+	return nil
+}
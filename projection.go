@@ -0,0 +1,46 @@
+package styx
+
+import (
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// WithProjection projects the query's solutions onto the given variables, so that
+// Next(nil) returns each distinct combination of their values exactly once. The rest
+// of the query's variables are existential, just like blank nodes: they can't be in
+// the domain before the projected variables, they come after them in the iterator's
+// domain, and Next(nil) doesn't move through their values. Projected variables that
+// don't occur in the query are ignored. Queries with optional groups can't be projected.
+func WithProjection(variables ...rdf.Term) QueryOption {
+	return func(options *queryOptions) {
+		options.projection = append(options.projection, variables...)
+		options.projected = true
+	}
+}
+
+// project sets the iterator's projection from the query's options
+func (iter *Iterator) project(options *queryOptions) error {
+	if !options.projected {
+		return nil
+	} else if len(options.optionals) > 0 {
+		return ErrProjection
+	}
+
+	iter.projection = make(map[string]bool, len(options.projection))
+	for _, node := range options.projection {
+		if node.TermType() != rdf.VariableType {
+			return ErrInvalidDomain
+		}
+		iter.projection[node.String()] = true
+	}
+	return nil
+}
+
+// hidden returns true if the node is a variable that the query's projection leaves out
+func (iter *Iterator) hidden(node rdf.Term) bool {
+	return iter.projection != nil && node.TermType() == rdf.VariableType && !iter.projection[node.String()]
+}
+
+// existential returns true if the node is a blank node or a hidden variable
+func (iter *Iterator) existential(node rdf.Term) bool {
+	return node.TermType() == rdf.BlankNodeType || iter.hidden(node)
+}
@@ -0,0 +1,110 @@
+package styx
+
+import (
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// Provenance is one of a query's quads with the values of a solution,
+// and the statements that assert it.
+type Provenance struct {
+	Quad       *rdf.Quad    `json:"quad"`
+	Statements []*Assertion `json:"statements"`
+}
+
+// An Assertion is a statement of a quad in a dataset
+type Assertion struct {
+	URI     string   `json:"uri"` // The dataset's URI followed by the statement's index
+	Graph   rdf.Term `json:"graph"`
+	Dataset rdf.Term `json:"dataset"`
+}
+
+// Prov returns the provenance of the iterator's current solution: the statements of
+// each of the query's quads that are in the quad's graph and the query's datasets,
+// in the order of the query's quads, followed by the quads of the optional groups
+// that the solution matches. Prov returns ErrEndOfSolutions if the iterator doesn't
+// have a current solution.
+func (iter *Iterator) Prov() ([]*Provenance, error) {
+	if iter.empty || iter.top {
+		return nil, ErrEndOfSolutions
+	} else if iter.branches != nil {
+		return iter.branches[iter.branch].Prov()
+	}
+
+	graph := iter.Graph()
+	prov := make([]*Provenance, len(graph))
+	for i, quad := range iter.query {
+		statements, err := iter.statements(quad)
+		if err != nil {
+			return nil, err
+		}
+
+		prov[i] = &Provenance{Quad: graph[i], Statements: make([]*Assertion, len(statements))}
+		for j, statement := range statements {
+			prov[i].Statements[j] = &Assertion{
+				URI:     statement.URI(iter.dictionary),
+				Graph:   statement.Graph(iter.dictionary),
+				Dataset: statement.Dataset(iter.dictionary),
+			}
+		}
+	}
+
+	for _, o := range iter.optionals {
+		if o.iter != nil {
+			p, err := o.iter.Prov()
+			if err != nil {
+				return nil, err
+			}
+			prov = append(prov, p...)
+		}
+	}
+
+	return prov, nil
+}
+
+// statements returns the statements of the quad with the current solution's
+// values that are in the quad's graph, if it has one, and in the query's datasets
+func (iter *Iterator) statements(quad *rdf.Quad) ([]*Statement, error) {
+	var terms [4]ID
+	for p, term := range quad {
+		if term.TermType() == rdf.DefaultGraphType {
+			continue
+		}
+
+		id, err := iter.id(term)
+		if err != nil {
+			return nil, err
+		}
+		terms[p] = id
+	}
+
+	item, err := iter.txn.Get(assembleKey(TernaryPrefixes[0], false, terms[:3]...))
+	if err != nil {
+		return nil, err
+	}
+
+	var statements []*Statement
+	err = item.Value(func(val []byte) (err error) {
+		statements, err = getStatements(val)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*Statement, 0, len(statements))
+	for _, statement := range statements {
+		if (terms[3] == NIL || statement.graph == terms[3]) && iter.sources.accepts(statement) {
+			result = append(result, statement)
+		}
+	}
+	return result, nil
+}
+
+// id returns the ID of a term of the query, which
+// is the current value of variables and blank nodes
+func (iter *Iterator) id(term rdf.Term) (ID, error) {
+	if i, has := iter.ids[term.String()]; has && i < len(iter.variables) {
+		return iter.variables[i].value, nil
+	}
+	return iter.dictionary.GetID(term, rdf.Default)
+}
@@ -2,6 +2,7 @@ package styx
 
 import (
 	"encoding/binary"
+	"sort"
 )
 
 // i, j, k, l... are int indices
@@ -21,9 +22,11 @@ func (iter *Iterator) next(i int) (tail int, err error) {
 			// It didn't work :-/
 			// This means we reset u, decrement i, and continue
 			u.value = u.Seek(u.root)
-			err = iter.push(u, i, iter.Len())
-			if err != nil {
-				return
+			if u.value != NIL {
+				err = iter.push(u, i, iter.Len())
+				if err != nil {
+					return
+				}
 			}
 
 			i--
@@ -42,12 +45,15 @@ func (iter *Iterator) next(i int) (tail int, err error) {
 		}
 
 		// Now set the `cursor` variable to our current index.
-		// If we fail to satisfy a variable in iter.out[i] while
+		// If we fail to satisfy a later variable while
 		// propagating here, then we'll set cursor to that failure index.
+		// We have to visit every later variable, not just the ones in iter.out[i],
+		// since the variables that we reset on the way down might have cleared
+		// the values of their own dependents.
 		cursor := i
 		// Don't recurse on i!
 		iter.blacklist[i] = true
-		for _, j := range iter.out[i] {
+		for j := i + 1; j < iter.Len(); j++ {
 			v := iter.variables[j]
 			// We have to give iter.tick(j, ...) a fresh cache here.
 			// TODO: there some memory saving stuff to be done about caches :-/
@@ -128,6 +134,11 @@ func (iter *Iterator) next(i int) (tail int, err error) {
 	return
 }
 
+func contains(indices []int, i int) bool {
+	x := sort.SearchInts(indices, i)
+	return x < len(indices) && indices[x] == i
+}
+
 func clear(delta []*vcache) {
 	for i, saved := range delta {
 		if saved != nil {
@@ -181,12 +192,14 @@ func (iter *Iterator) tick(i, min int, delta []*vcache) (ok bool, err error) {
 
 			// Fantastic. Now that we've propagated the value we found for v,
 			// we start "the crawl" from j to i, seeking to the new satisfying root
-			// and recursing on tick when necessary.
+			// and recursing on tick when necessary. Like an odometer, every
+			// variable between j and i gets reset, not just the ones in iter.out[j],
+			// since the values they had were only the first ones for the old v.value.
 			cursor := j
 			iter.blacklist[j] = true
-			for _, k := range iter.out[j] {
-				if k >= i {
-					break
+			for k := j + 1; k < i; k++ {
+				if iter.blacklist[k] && !contains(iter.out[j], k) {
+					continue
 				}
 
 				w := iter.variables[k]
@@ -198,8 +211,11 @@ func (iter *Iterator) tick(i, min int, delta []*vcache) (ok bool, err error) {
 				d := make([]*vcache, k)
 
 				// Here we keep seeking and ticking until we have a real value.
+				// The recursive tick only gets to change the variables after j,
+				// since the variables before j are held fixed while we walk
+				// through the values of v.
 				for w.value = w.Seek(w.root); w.value == NIL; w.value = w.Seek(w.root) {
-					if ok, err = iter.tick(k, min, d); err != nil {
+					if ok, err = iter.tick(k, j, d); err != nil {
 						return
 					} else if ok {
 						continue
@@ -218,22 +234,18 @@ func (iter *Iterator) tick(i, min int, delta []*vcache) (ok bool, err error) {
 					break
 				}
 
-				// We got a real value for w! Now we propagate the affected values
-				// through i and stash them into next if they're not there already,
-				// and then continue with the tick-crawl.
+				// We got a real value for w! Now we propagate it through i
+				// and stash the affected values into next if they're not there
+				// already, and then continue with the tick-crawl. The recursive
+				// tick already pushed the values that it changed, so pushing them
+				// again here would only clear the variables that it re-seeked.
 				err = iter.push(w, k, i)
 				if err != nil {
 					return
 				}
 				for l, saved := range d {
-					if saved != nil {
-						err = iter.push(iter.variables[l], l, i)
-						if err != nil {
-							return
-						}
-						if next[l] == nil {
-							next[l] = saved
-						}
+					if saved != nil && next[l] == nil {
+						next[l] = saved
 					}
 				}
 			}
@@ -284,7 +296,9 @@ func (iter *Iterator) restore(cache []*vcache, max int) (err error) {
 			iter.load(u, saved)
 			// u.load(saved)
 			// Push the restored state through the max
-			if err = iter.push(u, i, max); err != nil {
+			if u.value == NIL {
+				continue
+			} else if err = iter.push(u, i, max); err != nil {
 				return
 			}
 		}
@@ -297,6 +311,17 @@ func (iter *Iterator) push(u *variable, min, max int) (err error) {
 		if j >= min && j < max {
 			// Update the incoming D2 constraints by using .dual to find them
 			for _, c := range cs {
+				if c.path != nil {
+					// u is one end of a path, so the other end
+					// gets every node that it can reach from u.value
+					neighbor := c.neighbors[2-c.place]
+					neighbor.terms[c.place] = u.value
+					if err = neighbor.setPath(iter.txn); err != nil {
+						return
+					}
+					continue
+				}
+
 				// Since u has a value, all of its constraints are in consensus.
 				// That means we can freely access their iterators!
 				// In this case, all the iterators for the outgoing u.d2s have
@@ -305,6 +330,26 @@ func (iter *Iterator) push(u *variable, min, max int) (err error) {
 				i := c.place
 
 				v := iter.variables[j]
+				if i == 3 {
+					// u is a graph variable, so v's constraint
+					// can only have triples asserted in u.value
+					for p := 0; p < 3; p++ {
+						if v.node.Equal(c.quad[p]) {
+							c.neighbors[p].graph = u.value
+						}
+					}
+					continue
+				} else if v.node.Equal(c.quad[3]) {
+					// v is a graph variable, so its constraint gets the
+					// graphs of the triple once all three terms are known
+					neighbor := c.neighbors[3]
+					neighbor.terms[i] = u.value
+					if err = neighbor.setGraphs(iter.statistics, iter.txn); err != nil {
+						return
+					}
+					continue
+				}
+
 				m, n := (i+1)%3, (i+2)%3
 
 				place := i
@@ -319,12 +364,24 @@ func (iter *Iterator) push(u *variable, min, max int) (err error) {
 
 				item := c.iterator.Item()
 				meta := item.UserMeta()
-				if meta == UnaryPrefix {
+				if c.quad[i].Equal(c.quad[m]) || c.quad[i].Equal(c.quad[n]) {
+					// u is repeated in the triple, so the neighbor gets
+					// a ternary prefix with u's value in both places.
+					A := (place + 1) % 3
+					neighbor.terms[A], neighbor.terms[(A+1)%3] = u.value, u.value
+					neighbor.prefix = assembleKey(TernaryPrefixes[A], true, u.value, u.value)
+					neighbor.count, err = iter.binary.Get(A, u.value, u.value, iter.txn)
+				} else if neighbor.repeated() {
+					// v is repeated in the triple, so the neighbor gets a binary
+					// prefix with u's value and checks the rest with .reflexive().
+					neighbor.prefix = assembleKey(BinaryPrefixes[i], true, u.value)
+					neighbor.count, err = iter.unary.Get(i, u.value, iter.txn)
+				} else if meta == UnaryPrefix {
+					// c is a third-degree constraint that hasn't been pushed into yet,
+					// so the neighbor gets a binary prefix with u's value.
 					var p Permutation = i
-					if place == m {
-						p = place
-					} else if place == n {
-						p = place + 3
+					if place == n {
+						p = i + 3
 					}
 					neighbor.prefix = assembleKey(BinaryPrefixes[p], true, u.value)
 					neighbor.count, err = iter.unary.Get(p, u.value, iter.txn)
@@ -0,0 +1,56 @@
+package styx
+
+import (
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// Prev moves the iterator back to the previous result that differs in the given node,
+// and returns the values that changed, just like Next. If nil is passed, the last node
+// in the domain is used. Next and Prev can be called in any order: Next returns the
+// results after the current one in ascending order, and Prev returns the results before
+// it in descending order. Once either of them runs out of results, the iterator has to
+// be seeked again. Queries with unions or optional groups can't be reversed.
+func (iter *Iterator) Prev(node rdf.Term) ([]rdf.Term, error) {
+	if iter.limited && iter.returned >= iter.limit {
+		return nil, nil
+	} else if err := iter.turn(true); err != nil {
+		return nil, err
+	}
+
+	d, err := iter.advance(node)
+	if d != nil {
+		iter.returned++
+	}
+	return d, err
+}
+
+// SeekReverse moves the iterator to the last result less than or equal to the
+// given index path, which is the first result that Prev returns. Results that
+// start with the index count as equal to it, so SeekReverse(nil) moves the
+// iterator to its very last result.
+func (iter *Iterator) SeekReverse(index []rdf.Term) error {
+	return iter.seekIndex(index, true)
+}
+
+// turn sets the direction that the iterator moves in. Moving in the other
+// direction starts from the current solution, which has already been returned.
+func (iter *Iterator) turn(reverse bool) error {
+	if iter.reverse == reverse || iter.empty {
+		return nil
+	} else if iter.branches != nil || len(iter.optionals) > 0 {
+		return ErrReverse
+	} else if iter.partitions != nil {
+		// The partitions only evaluate their ranges in ascending order
+		if err := iter.sequential(); err != nil {
+			return err
+		}
+	}
+
+	iter.reverse = reverse
+	iter.bot = false
+	for _, u := range iter.variables {
+		u.turn(reverse)
+	}
+
+	return nil
+}
@@ -50,6 +50,7 @@ func (s *Store) Set(node rdf.Term, dataset []*rdf.Quad) (err error) {
 
 	uc := newUnaryCache()
 	bc := newBinaryCache()
+	gc := newGraphCache()
 
 	origin, err := dictionary.GetID(node, rdf.Default)
 	if err != nil {
@@ -66,6 +67,13 @@ func (s *Store) Set(node rdf.Term, dataset []*rdf.Quad) (err error) {
 		}
 	}
 
+	// deleteQuads has already committed its changes
+	// to the statistics, so we read them afterwards.
+	st, err := getStatistics(badgerView{txn})
+	if err != nil {
+		return
+	}
+
 	quads = make([][4]ID, len(dataset))
 
 	var terms [3]ID
@@ -98,6 +106,11 @@ func (s *Store) Set(node rdf.Term, dataset []*rdf.Quad) (err error) {
 			}
 		}
 
+		err = gc.Increment(source.graph, st, txn)
+		if err != nil {
+			return
+		}
+
 		for p := Permutation(0); p < 3; p++ {
 			a, b, c := major.permute(p, terms)
 			key := assembleKey(TernaryPrefixes[p], false, a, b, c)
@@ -105,16 +118,17 @@ func (s *Store) Set(node rdf.Term, dataset []*rdf.Quad) (err error) {
 			if err == badger.ErrKeyNotFound {
 				// Since this is a new key we have to increment two binary keys.
 				ab, ba := p, ((p+1)%3)+3
-				err = bc.Increment(ab, a, b, uc, txn)
+				err = bc.Increment(ab, a, b, uc, st, txn)
 				if err != nil {
 					return
 				}
-				err = bc.Increment(ba, b, a, uc, txn)
+				err = bc.Increment(ba, b, a, uc, st, txn)
 				if err != nil {
 					return
 				}
 				if p == 0 {
 					val = []byte(source.String())
+					st.triples++
 				}
 				txn, err = setSafe(key, val, txn, s.Badger)
 				if err != nil {
@@ -146,6 +160,16 @@ func (s *Store) Set(node rdf.Term, dataset []*rdf.Quad) (err error) {
 		return
 	}
 
+	txn, err = gc.Commit(s.Badger, txn)
+	if err != nil {
+		return
+	}
+
+	txn, err = st.Commit(s.Badger, txn)
+	if err != nil {
+		return
+	}
+
 	err = txn.Commit()
 	if err != nil {
 		return
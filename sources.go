@@ -0,0 +1,103 @@
+package styx
+
+import (
+	"sort"
+	"strings"
+
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// A Source decides which datasets a query draws its triples from.
+// Like a TagScheme, it tests URIs, and any TagScheme is also a Source.
+type Source interface {
+	Test(dataset string) bool
+}
+
+// SourceFunc is a Source that accepts the datasets that the function returns true for
+type SourceFunc func(dataset string) bool
+
+// Test calls the function
+func (f SourceFunc) Test(dataset string) bool { return f(dataset) }
+
+type datasetSource struct {
+	datasets map[string]bool
+	allow    bool
+}
+
+// AllowDatasets creates a source that only accepts the given datasets
+func AllowDatasets(datasets ...string) Source { return newDatasetSource(datasets, true) }
+
+// DenyDatasets creates a source that accepts every dataset except the given ones
+func DenyDatasets(datasets ...string) Source { return newDatasetSource(datasets, false) }
+
+func newDatasetSource(datasets []string, allow bool) datasetSource {
+	source := datasetSource{datasets: make(map[string]bool, len(datasets)), allow: allow}
+	for _, dataset := range datasets {
+		source.datasets[dataset] = true
+	}
+	return source
+}
+
+func (ds datasetSource) Test(dataset string) bool { return ds.datasets[dataset] == ds.allow }
+
+// String lists the datasets in order, so that cursors can tell sources apart
+func (ds datasetSource) String() string {
+	datasets := make([]string, 0, len(ds.datasets))
+	for dataset := range ds.datasets {
+		datasets = append(datasets, "<"+dataset+">")
+	}
+	sort.Strings(datasets)
+	if ds.allow {
+		return "ALLOW " + strings.Join(datasets, " ")
+	}
+	return "DENY " + strings.Join(datasets, " ")
+}
+
+// WithSources restricts the query to the triples that the source's datasets assert.
+// A triple only satisfies the query's patterns if at least one of its statements comes
+// from a dataset that the source accepts, and Iterator.Prov only returns those statements.
+// Optional groups, negations, and unions are restricted to the same datasets.
+func WithSources(source Source) QueryOption {
+	return func(options *queryOptions) {
+		options.source = source
+	}
+}
+
+// sources is a query's source, with the results of the source's
+// tests for the dataset IDs that the query has come across
+type sources struct {
+	source     Source
+	dictionary Dictionary
+	accepted   map[iri]bool
+}
+
+func newSources(source Source, dictionary Dictionary) *sources {
+	if source == nil {
+		return nil
+	}
+	return &sources{source: source, dictionary: dictionary, accepted: map[iri]bool{}}
+}
+
+// accepts returns true if the statement comes from one of the query's datasets.
+// Every statement is accepted if the query isn't restricted to some datasets.
+func (s *sources) accepts(statement *Statement) bool {
+	if s == nil {
+		return true
+	}
+
+	ok, has := s.accepted[statement.base]
+	if !has {
+		dataset, err := s.dictionary.GetTerm(ID(statement.base), rdf.Default)
+		ok = err == nil && s.source.Test(dataset.Value())
+		s.accepted[statement.base] = ok
+	}
+	return ok
+}
+
+// inherit restricts the options of a query within the iterator's
+// query to the same datasets, unless they have their own source
+func (iter *Iterator) inherit(options *queryOptions) {
+	if iter.sources != nil && options.source == nil {
+		options.source = iter.sources.source
+	}
+}
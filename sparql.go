@@ -0,0 +1,1092 @@
+package styx
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	ld "github.com/piprate/json-gold/ld"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// A SPARQLQuery is a SPARQL query compiled into the arguments of Store.Query.
+// The query's pattern is evaluated with a nil index, and the projected
+// variables come first in the domain.
+type SPARQLQuery struct {
+	Ask       bool        // Whether the query is an ASK query instead of a SELECT query
+	Template  []*rdf.Quad // The template of a CONSTRUCT query, or nil for other queries
+	Variables []rdf.Term  // The projected variables, in the order of the SELECT clause
+	Pattern   []*rdf.Quad // The triples of the WHERE clause
+	Domain    []rdf.Term  // The projected variables that occur in the pattern
+	Options   []QueryOption
+	Limit     int // The most solutions to return, or -1 for no limit
+	Offset    int // The number of solutions to skip
+}
+
+// ParseSPARQL parses a SPARQL SELECT, ASK, or CONSTRUCT query. The supported constructs are
+// PREFIX and BASE declarations, projections of variables or * with DISTINCT or REDUCED,
+// basic graph patterns, FILTER with comparisons, REGEX, LANG, DATATYPE, STR, and the
+// logical operators, and LIMIT and OFFSET. Other constructs return an error naming the construct.
+func ParseSPARQL(query string) (*SPARQLQuery, error) {
+	parser := &sparqlParser{input: query, prefixes: map[string]string{}}
+	return parser.parse()
+}
+
+// QuerySPARQL parses a SPARQL query and evaluates its pattern
+func (s *Store) QuerySPARQL(query string) (*SPARQLQuery, *Iterator, error) {
+	q, err := ParseSPARQL(query)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	iter, err := s.Query(q.Pattern, q.Domain, nil, q.Options...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return q, iter, nil
+}
+
+// Results returns the values of the projected variables for the iterator's
+// solutions, after skipping the query's offset and up to the query's limit.
+// ASK queries have no projected variables, and get a single empty row
+// if the pattern has a solution.
+func (query *SPARQLQuery) Results(iter *Iterator) ([][]rdf.Term, error) {
+	limit := query.Limit
+	if query.Ask && (limit < 0 || limit > 1) {
+		limit = 1
+	}
+
+	result := [][]rdf.Term{}
+	err := query.solutions(iter, limit, func() error {
+		row := make([]rdf.Term, len(query.Variables))
+		for j, node := range query.Variables {
+			row[j] = iter.Get(node)
+		}
+		result = append(result, row)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Construct passes the quads of a CONSTRUCT query's template to emit, instantiated
+// for the iterator's solutions after the query's offset and up to its limit.
+// Like Iterator.Construct, every quad is only emitted once.
+func (query *SPARQLQuery) Construct(iter *Iterator, emit func(*rdf.Quad) error) error {
+	c := newConstruction(query.Template)
+	return query.solutions(iter, query.Limit, func() error {
+		return c.instantiate(iter, emit)
+	})
+}
+
+// solutions calls f for each of the iterator's solutions after the query's offset,
+// and up to the limit, which is -1 for no limit
+func (query *SPARQLQuery) solutions(iter *Iterator, limit int, f func() error) error {
+	if iter.empty {
+		return nil
+	}
+
+	if _, err := iter.Skip(query.Offset); err != nil {
+		return err
+	}
+
+	for i := 0; limit < 0 || i < limit; i++ {
+		d, err := iter.Next(nil)
+		if err != nil {
+			return err
+		} else if d == nil {
+			break
+		} else if err = f(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type sparqlParser struct {
+	input    string
+	pos      int
+	base     *url.URL
+	prefixes map[string]string
+	blank    int
+	query    *SPARQLQuery
+	filters  []*Filter
+	template bool // Whether the parser is in the template of a CONSTRUCT query
+	distinct bool // Whether the query is a SELECT DISTINCT or SELECT REDUCED query
+}
+
+func (p *sparqlParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("Invalid SPARQL query at offset %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+func unsupported(construct string) error {
+	return fmt.Errorf("Unsupported SPARQL construct: %s", construct)
+}
+
+func (p *sparqlParser) parse() (*SPARQLQuery, error) {
+	p.query = &SPARQLQuery{Limit: -1}
+
+	if err := p.parsePrologue(); err != nil {
+		return nil, err
+	}
+
+	star, construct := false, false
+	switch keyword := strings.ToUpper(p.peekWord()); keyword {
+	case "SELECT":
+		p.pos += len(keyword)
+		if err := p.parseProjection(&star); err != nil {
+			return nil, err
+		}
+	case "ASK":
+		p.pos += len(keyword)
+		p.query.Ask = true
+	case "CONSTRUCT":
+		p.pos += len(keyword)
+		construct = true
+		if err := p.parseTemplate(); err != nil {
+			return nil, err
+		}
+	case "DESCRIBE":
+		return nil, unsupported(keyword)
+	case "":
+		return nil, p.errorf("expected SELECT, ASK, or CONSTRUCT")
+	default:
+		return nil, p.errorf("unexpected %q", keyword)
+	}
+
+	p.skip()
+	if keyword := strings.ToUpper(p.peekWord()); keyword == "FROM" {
+		return nil, unsupported(keyword)
+	} else if keyword == "WHERE" {
+		p.pos += len(keyword)
+	}
+
+	if err := p.parseGroup(); err != nil {
+		return nil, err
+	}
+
+	// The short form CONSTRUCT WHERE uses the pattern as the template
+	if construct && p.query.Template == nil {
+		if len(p.filters) > 0 {
+			return nil, p.errorf("CONSTRUCT WHERE can't have filters")
+		}
+		p.query.Template = p.query.Pattern
+	}
+
+	if err := p.parseModifiers(); err != nil {
+		return nil, err
+	}
+
+	p.skip()
+	if p.pos < len(p.input) {
+		if keyword := strings.ToUpper(p.peekWord()); keyword == "VALUES" {
+			return nil, unsupported(keyword)
+		}
+		return nil, p.errorf("unexpected %q", p.rest())
+	}
+
+	// Every variable in the pattern is projected by SELECT *
+	if star {
+		seen := map[string]bool{}
+		for _, quad := range p.query.Pattern {
+			for _, term := range quad[:3] {
+				if term.TermType() == rdf.VariableType && !seen[term.String()] {
+					seen[term.String()] = true
+					p.query.Variables = append(p.query.Variables, term)
+				}
+			}
+		}
+	}
+
+	p.query.Domain = []rdf.Term{}
+	for _, node := range p.query.Variables {
+		if mentions(p.query.Pattern, node) {
+			p.query.Domain = append(p.query.Domain, node)
+		}
+	}
+
+	if len(p.filters) > 0 {
+		p.query.Options = append(p.query.Options, WithFilters(p.filters...))
+	}
+
+	if p.distinct {
+		p.query.Options = append(p.query.Options, WithProjection(p.query.Domain...))
+	}
+
+	return p.query, nil
+}
+
+func (p *sparqlParser) parsePrologue() error {
+	for {
+		p.skip()
+		switch keyword := strings.ToUpper(p.peekWord()); keyword {
+		case "BASE":
+			p.pos += len(keyword)
+			p.skip()
+			iri, err := p.parseIRI()
+			if err != nil {
+				return err
+			}
+			p.base, err = url.Parse(iri.Value())
+			if err != nil {
+				return p.errorf("invalid base IRI %s", iri)
+			}
+		case "PREFIX":
+			p.pos += len(keyword)
+			p.skip()
+			name := p.readName()
+			if !p.consume(":") {
+				return p.errorf("expected a prefix name")
+			}
+			p.skip()
+			iri, err := p.parseIRI()
+			if err != nil {
+				return err
+			}
+			p.prefixes[name] = iri.Value()
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *sparqlParser) parseProjection(star *bool) error {
+	p.skip()
+	if keyword := strings.ToUpper(p.peekWord()); keyword == "DISTINCT" || keyword == "REDUCED" {
+		p.pos += len(keyword)
+		p.distinct = true
+		p.skip()
+	}
+
+	if p.consume("*") {
+		*star = true
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for {
+		p.skip()
+		if p.peek() == '(' {
+			return unsupported("projection expressions")
+		} else if c := p.peek(); c != '?' && c != '$' {
+			break
+		}
+
+		v, err := p.parseVariable()
+		if err != nil {
+			return err
+		} else if seen[v.String()] {
+			return p.errorf("duplicate projected variable %s", v)
+		}
+		seen[v.String()] = true
+		p.query.Variables = append(p.query.Variables, v)
+	}
+
+	if len(p.query.Variables) == 0 {
+		return p.errorf("expected variables or * after SELECT")
+	}
+	return nil
+}
+
+// parseTemplate parses the template of a CONSTRUCT query, which is
+// a group of triples, unless the query uses the short form CONSTRUCT WHERE
+func (p *sparqlParser) parseTemplate() error {
+	p.template = true
+	defer func() { p.template = false }()
+
+	p.skip()
+	if strings.ToUpper(p.peekWord()) == "WHERE" {
+		return nil
+	} else if !p.consume("{") {
+		return p.errorf("expected {")
+	}
+
+	p.query.Template = []*rdf.Quad{}
+	for {
+		p.skip()
+		if p.consume("}") {
+			return nil
+		} else if p.pos >= len(p.input) {
+			return p.errorf("expected }")
+		} else if keyword := strings.ToUpper(p.peekWord()); keyword != "" {
+			return unsupported(keyword + " in CONSTRUCT templates")
+		} else if err := p.parseTriples(); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *sparqlParser) parseGroup() error {
+	p.skip()
+	if !p.consume("{") {
+		return p.errorf("expected {")
+	}
+
+	for {
+		p.skip()
+		if p.consume("}") {
+			break
+		} else if p.pos >= len(p.input) {
+			return p.errorf("expected }")
+		} else if p.peek() == '{' {
+			return unsupported("nested group patterns")
+		}
+
+		switch keyword := strings.ToUpper(p.peekWord()); keyword {
+		case "FILTER":
+			p.pos += len(keyword)
+			if err := p.parseFilter(); err != nil {
+				return err
+			}
+			p.skip()
+			p.consume(".")
+		case "OPTIONAL", "UNION", "MINUS", "GRAPH", "BIND", "VALUES", "SERVICE":
+			return unsupported(keyword)
+		default:
+			if err := p.parseTriples(); err != nil {
+				return err
+			}
+		}
+	}
+
+	p.skip()
+	if keyword := strings.ToUpper(p.peekWord()); keyword == "UNION" {
+		return unsupported(keyword)
+	}
+
+	return nil
+}
+
+// parseTriples parses the triples with one subject, up to and including the
+// period that ends them, or up to the end of the group if there isn't one
+func (p *sparqlParser) parseTriples() error {
+	// Blank node property lists don't need any other predicates
+	list := p.peek() == '['
+	subject, err := p.parseSubject()
+	if err != nil {
+		return err
+	}
+
+	p.skip()
+	if c := p.peek(); !list || c != '.' && c != '}' {
+		if err := p.parsePredicateObjects(subject); err != nil {
+			return err
+		}
+	}
+
+	p.skip()
+	if !p.consume(".") && p.peek() != '}' && p.peekWord() == "" {
+		return p.errorf("expected . or }")
+	}
+	return nil
+}
+
+func (p *sparqlParser) parseSubject() (rdf.Term, error) {
+	p.skip()
+	switch p.peek() {
+	case '[':
+		return p.parseBlankNodePropertyList()
+	case '(':
+		return nil, unsupported("collections")
+	}
+
+	term, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	} else if term.TermType() == rdf.LiteralType {
+		return nil, p.errorf("literal %s can't be a subject", term)
+	}
+	return term, nil
+}
+
+func (p *sparqlParser) parsePredicateObjects(subject rdf.Term) error {
+	for {
+		predicate, err := p.parsePredicate()
+		if err != nil {
+			return err
+		}
+
+		for {
+			object, err := p.parseObject()
+			if err != nil {
+				return err
+			}
+
+			quad := rdf.NewQuad(subject, predicate, object, nil)
+			if p.template {
+				p.query.Template = append(p.query.Template, quad)
+			} else {
+				p.query.Pattern = append(p.query.Pattern, quad)
+			}
+			p.skip()
+			if !p.consume(",") {
+				break
+			}
+		}
+
+		p.skip()
+		if !p.consume(";") {
+			return nil
+		}
+
+		// Predicate-object lists can end with a semicolon
+		for p.skip(); p.consume(";"); p.skip() {
+		}
+		if c := p.peek(); c == '.' || c == '}' || c == ']' {
+			return nil
+		}
+	}
+}
+
+func (p *sparqlParser) parsePredicate() (rdf.Term, error) {
+	p.skip()
+	var predicate rdf.Term
+	if p.peek() == 'a' && !isNameChar(p.peekAt(1)) && p.peekAt(1) != ':' {
+		p.pos++
+		predicate = rdf.NewNamedNode(ld.RDFType)
+	} else if c := p.peek(); c == '^' || c == '(' || c == '!' {
+		return nil, unsupported("property paths")
+	} else {
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		} else if t := term.TermType(); t == rdf.VariableType {
+			return term, nil
+		} else if t != rdf.NamedNodeType {
+			return nil, p.errorf("%s can't be a predicate", term)
+		}
+		predicate = term
+	}
+
+	if c := p.peek(); c == '+' || c == '*' || c == '?' {
+		return nil, unsupported("property paths")
+	} else if p.skip(); p.peek() == '/' || p.peek() == '|' {
+		return nil, unsupported("property paths")
+	}
+	return predicate, nil
+}
+
+func (p *sparqlParser) parseObject() (rdf.Term, error) {
+	p.skip()
+	switch p.peek() {
+	case '[':
+		return p.parseBlankNodePropertyList()
+	case '(':
+		return nil, unsupported("collections")
+	}
+	return p.parseTerm()
+}
+
+func (p *sparqlParser) parseBlankNodePropertyList() (rdf.Term, error) {
+	p.consume("[")
+	node := p.newBlankNode()
+	p.skip()
+	if p.consume("]") {
+		return node, nil
+	}
+
+	if err := p.parsePredicateObjects(node); err != nil {
+		return nil, err
+	}
+
+	p.skip()
+	if !p.consume("]") {
+		return nil, p.errorf("expected ]")
+	}
+	return node, nil
+}
+
+// newBlankNode returns a blank node for an anonymous node in the query.
+// Their labels start with a hyphen, which blank node labels in
+// the query itself can't, so they never collide.
+func (p *sparqlParser) newBlankNode() rdf.Term {
+	node := rdf.NewBlankNode(fmt.Sprintf("-%d", p.blank))
+	p.blank++
+	return node
+}
+
+// parseTerm parses a variable, IRI, prefixed name, blank node, or literal
+func (p *sparqlParser) parseTerm() (rdf.Term, error) {
+	p.skip()
+	switch c := p.peek(); {
+	case c == '?' || c == '$':
+		return p.parseVariable()
+	case c == '<':
+		return p.parseIRI()
+	case c == '_' && p.peekAt(1) == ':':
+		p.pos += 2
+		label := p.readName()
+		if label == "" {
+			return nil, p.errorf("expected a blank node label")
+		}
+		return rdf.NewBlankNode(label), nil
+	case c == '"' || c == '\'':
+		return p.parseLiteral()
+	case c == '+' || c == '-' || c == '.' || '0' <= c && c <= '9':
+		return p.parseNumber()
+	case c == 0:
+		return nil, p.errorf("unexpected end of query")
+	}
+
+	start := p.pos
+	name := p.readName()
+	if p.peek() == ':' {
+		p.pos = start
+		return p.parsePrefixedName()
+	} else if name == "true" || name == "false" {
+		return rdf.NewLiteral(name, "", rdf.NewNamedNode(ld.XSDBoolean)), nil
+	}
+
+	p.pos = start
+	return nil, p.errorf("unexpected %q", p.rest())
+}
+
+func (p *sparqlParser) parseVariable() (rdf.Term, error) {
+	p.pos++
+	name := p.readName()
+	if name == "" || strings.ContainsAny(name, ".-") {
+		return nil, p.errorf("invalid variable name %q", name)
+	}
+	return rdf.NewVariable(name), nil
+}
+
+func (p *sparqlParser) parseIRI() (*rdf.NamedNode, error) {
+	if !p.consume("<") {
+		return nil, p.errorf("expected an IRI")
+	}
+
+	end := strings.IndexAny(p.input[p.pos:], "> \t\r\n")
+	if end == -1 || p.input[p.pos+end] != '>' {
+		return nil, p.errorf("unterminated IRI")
+	}
+
+	iri := p.input[p.pos : p.pos+end]
+	p.pos += end + 1
+	if p.base != nil {
+		ref, err := url.Parse(iri)
+		if err != nil {
+			return nil, p.errorf("invalid IRI <%s>", iri)
+		}
+		iri = p.base.ResolveReference(ref).String()
+	}
+
+	return rdf.NewNamedNode(iri), nil
+}
+
+func (p *sparqlParser) parsePrefixedName() (rdf.Term, error) {
+	prefix := p.readName()
+	p.consume(":")
+
+	namespace, has := p.prefixes[prefix]
+	if !has {
+		return nil, p.errorf("undefined prefix %q", prefix)
+	}
+
+	var local strings.Builder
+	for p.pos < len(p.input) {
+		c := p.peek()
+		if c == '\\' && p.pos+1 < len(p.input) {
+			local.WriteByte(p.input[p.pos+1])
+			p.pos += 2
+		} else if c == '%' && p.pos+2 < len(p.input) {
+			local.WriteString(p.input[p.pos : p.pos+3])
+			p.pos += 3
+		} else if c == ':' || c == '.' || isNameChar(c) {
+			local.WriteRune(p.next())
+		} else {
+			break
+		}
+	}
+
+	// Local names can't end with a period,
+	// which ends the triple instead
+	name := local.String()
+	for strings.HasSuffix(name, ".") {
+		name = name[:len(name)-1]
+		p.pos--
+	}
+
+	return rdf.NewNamedNode(namespace + name), nil
+}
+
+var sparqlEscapes = map[byte]string{
+	't': "\t", 'b': "\b", 'n': "\n", 'r': "\r", 'f': "\f", '"': "\"", '\'': "'", '\\': "\\",
+}
+
+func (p *sparqlParser) parseLiteral() (rdf.Term, error) {
+	quote := p.input[p.pos : p.pos+1]
+	if strings.HasPrefix(p.input[p.pos:], strings.Repeat(quote, 3)) {
+		quote = strings.Repeat(quote, 3)
+	}
+	p.pos += len(quote)
+
+	var value strings.Builder
+	for {
+		if p.pos >= len(p.input) {
+			return nil, p.errorf("unterminated string")
+		} else if strings.HasPrefix(p.input[p.pos:], quote) {
+			p.pos += len(quote)
+			break
+		}
+
+		c := p.input[p.pos]
+		if c == '\\' {
+			if p.pos+1 >= len(p.input) {
+				return nil, p.errorf("unterminated string")
+			}
+			e := p.input[p.pos+1]
+			if s, has := sparqlEscapes[e]; has {
+				value.WriteString(s)
+				p.pos += 2
+			} else if e == 'u' || e == 'U' {
+				n := 4
+				if e == 'U' {
+					n = 8
+				}
+				if p.pos+2+n > len(p.input) {
+					return nil, p.errorf("invalid escape sequence")
+				}
+				r, err := strconv.ParseUint(p.input[p.pos+2:p.pos+2+n], 16, 32)
+				if err != nil {
+					return nil, p.errorf("invalid escape sequence")
+				}
+				value.WriteRune(rune(r))
+				p.pos += 2 + n
+			} else {
+				return nil, p.errorf("invalid escape sequence \\%c", e)
+			}
+		} else if len(quote) == 1 && (c == '\n' || c == '\r') {
+			return nil, p.errorf("unterminated string")
+		} else {
+			value.WriteRune(p.next())
+		}
+	}
+
+	if p.consume("@") {
+		language := p.readName()
+		if language == "" {
+			return nil, p.errorf("expected a language tag")
+		}
+		return rdf.NewLiteral(value.String(), language, rdf.RDFLangString), nil
+	} else if p.consume("^^") {
+		var datatype rdf.Term
+		var err error
+		if p.peek() == '<' {
+			datatype, err = p.parseIRI()
+		} else {
+			datatype, err = p.parsePrefixedName()
+		}
+		if err != nil {
+			return nil, err
+		} else if datatype.Value() == ld.XSDString {
+			return rdf.NewLiteral(value.String(), "", nil), nil
+		}
+		return rdf.NewLiteral(value.String(), "", rdf.NewNamedNode(datatype.Value())), nil
+	}
+
+	return rdf.NewLiteral(value.String(), "", nil), nil
+}
+
+func (p *sparqlParser) parseNumber() (rdf.Term, error) {
+	start := p.pos
+	if c := p.peek(); c == '+' || c == '-' {
+		p.pos++
+	}
+
+	digits := func() int {
+		n := 0
+		for c := p.peek(); '0' <= c && c <= '9'; c = p.peek() {
+			p.pos++
+			n++
+		}
+		return n
+	}
+
+	datatype := ld.XSDInteger
+	n := digits()
+	if p.peek() == '.' && '0' <= p.peekAt(1) && p.peekAt(1) <= '9' {
+		p.pos++
+		n += digits()
+		datatype = ld.XSDDecimal
+	}
+
+	if n == 0 {
+		p.pos = start
+		return nil, p.errorf("unexpected %q", p.rest())
+	}
+
+	if c := p.peek(); c == 'e' || c == 'E' {
+		p.pos++
+		if c := p.peek(); c == '+' || c == '-' {
+			p.pos++
+		}
+		if digits() == 0 {
+			return nil, p.errorf("invalid exponent")
+		}
+		datatype = ld.XSDDouble
+	}
+
+	return rdf.NewLiteral(p.input[start:p.pos], "", rdf.NewNamedNode(datatype)), nil
+}
+
+func (p *sparqlParser) parseModifiers() error {
+	for {
+		p.skip()
+		switch keyword := strings.ToUpper(p.peekWord()); keyword {
+		case "LIMIT", "OFFSET":
+			p.pos += len(keyword)
+			p.skip()
+			start := p.pos
+			for c := p.peek(); '0' <= c && c <= '9'; c = p.peek() {
+				p.pos++
+			}
+			n, err := strconv.Atoi(p.input[start:p.pos])
+			if err != nil {
+				p.pos = start
+				return p.errorf("expected an integer after %s", keyword)
+			}
+			if keyword == "LIMIT" {
+				p.query.Limit = n
+			} else {
+				p.query.Offset = n
+			}
+		case "GROUP", "ORDER":
+			return unsupported(keyword + " BY")
+		case "HAVING":
+			return unsupported(keyword)
+		default:
+			return nil
+		}
+	}
+}
+
+// A sparqlExpression is either a value or a filter, since
+// SPARQL doesn't distinguish between the two syntactically.
+type sparqlExpression struct {
+	value  Expression
+	filter *Filter
+}
+
+func (p *sparqlParser) parseFilter() error {
+	p.skip()
+	var e *sparqlExpression
+	var err error
+	if p.peek() == '(' {
+		e, err = p.parseBracketted()
+	} else {
+		e, err = p.parseCall()
+	}
+
+	if err != nil {
+		return err
+	} else if e.filter == nil {
+		return unsupported("effective boolean values")
+	}
+
+	p.filters = append(p.filters, e.filter)
+	return nil
+}
+
+func (p *sparqlParser) parseBracketted() (*sparqlExpression, error) {
+	p.skip()
+	if !p.consume("(") {
+		return nil, p.errorf("expected (")
+	}
+
+	e, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skip()
+	if !p.consume(")") {
+		return nil, p.errorf("expected )")
+	}
+	return e, nil
+}
+
+func (p *sparqlParser) parseOr() (*sparqlExpression, error) {
+	return p.parseLogical("||", p.parseAnd, Or)
+}
+
+func (p *sparqlParser) parseAnd() (*sparqlExpression, error) {
+	return p.parseLogical("&&", p.parseUnary, And)
+}
+
+func (p *sparqlParser) parseLogical(op string, parse func() (*sparqlExpression, error), combine func(...*Filter) *Filter) (*sparqlExpression, error) {
+	e, err := parse()
+	if err != nil {
+		return nil, err
+	}
+
+	filters := []*Filter{e.filter}
+	for p.skip(); p.consume(op); p.skip() {
+		next, err := parse()
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, next.filter)
+	}
+
+	if len(filters) == 1 {
+		return e, nil
+	}
+
+	for _, filter := range filters {
+		if filter == nil {
+			return nil, unsupported("effective boolean values")
+		}
+	}
+	return &sparqlExpression{filter: combine(filters...)}, nil
+}
+
+func (p *sparqlParser) parseUnary() (*sparqlExpression, error) {
+	p.skip()
+	if p.peek() == '!' && p.peekAt(1) != '=' {
+		p.pos++
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		} else if e.filter == nil {
+			return nil, unsupported("effective boolean values")
+		}
+		return &sparqlExpression{filter: Not(e.filter)}, nil
+	}
+
+	return p.parseRelational()
+}
+
+var sparqlOperators = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+func (p *sparqlParser) parseRelational() (*sparqlExpression, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skip()
+	for _, op := range sparqlOperators {
+		if !strings.HasPrefix(p.input[p.pos:], op) {
+			continue
+		}
+
+		p.pos += len(op)
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		} else if left.value == nil || right.value == nil {
+			return nil, unsupported("comparisons of boolean expressions")
+		}
+
+		filter, err := Compare(left.value, op, right.value)
+		if err != nil {
+			return nil, err
+		}
+		return &sparqlExpression{filter: filter}, nil
+	}
+
+	if c := p.peek(); c == '+' || c == '-' || c == '*' || c == '/' {
+		return nil, unsupported("arithmetic")
+	} else if keyword := strings.ToUpper(p.peekWord()); keyword == "IN" || keyword == "NOT" {
+		return nil, unsupported(keyword)
+	}
+
+	return left, nil
+}
+
+func (p *sparqlParser) parsePrimary() (*sparqlExpression, error) {
+	p.skip()
+	switch c := p.peek(); {
+	case c == '(':
+		return p.parseBracketted()
+	case c == '?' || c == '$' || c == '<' || c == '"' || c == '\'' || c == '_':
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return &sparqlExpression{value: Node(term)}, nil
+	case c == '+' || c == '-' || c == '.' || '0' <= c && c <= '9':
+		term, err := p.parseNumber()
+		if err != nil {
+			return nil, err
+		}
+		return &sparqlExpression{value: Node(term)}, nil
+	}
+
+	start := p.pos
+	name := p.readName()
+	if p.peek() == ':' || name == "true" || name == "false" {
+		p.pos = start
+		term, err := p.parseTerm()
+		if err != nil {
+			return nil, err
+		}
+		return &sparqlExpression{value: Node(term)}, nil
+	}
+
+	p.pos = start
+	return p.parseCall()
+}
+
+// parseCall parses a call to one of the supported built-in functions
+func (p *sparqlParser) parseCall() (*sparqlExpression, error) {
+	p.skip()
+	name := strings.ToUpper(p.readName())
+	if name == "" {
+		return nil, p.errorf("unexpected %q", p.rest())
+	} else if name == "NOT" || name == "EXISTS" {
+		return nil, unsupported("FILTER EXISTS and NOT EXISTS")
+	}
+
+	p.skip()
+	if !p.consume("(") {
+		return nil, p.errorf("expected ( after %s", name)
+	}
+
+	args := []*sparqlExpression{}
+	for p.skip(); !p.consume(")"); p.skip() {
+		if len(args) > 0 && !p.consume(",") {
+			return nil, p.errorf("expected , or )")
+		}
+
+		arg, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+
+	for _, arg := range args {
+		if arg.value == nil {
+			return nil, unsupported("boolean arguments to " + name)
+		}
+	}
+
+	switch name {
+	case "LANG", "DATATYPE", "STR":
+		if len(args) != 1 {
+			return nil, p.errorf("%s takes one argument", name)
+		}
+		f := map[string]func(Expression) Expression{"LANG": Lang, "DATATYPE": Datatype, "STR": Str}[name]
+		return &sparqlExpression{value: f(args[0].value)}, nil
+	case "REGEX":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, p.errorf("REGEX takes two or three arguments")
+		}
+
+		// The pattern and flags have to be constant strings
+		strs := make([]string, 2)
+		for i, arg := range args[1:] {
+			node, is := arg.value.(nodeExpression)
+			if !is || node.term.TermType() != rdf.LiteralType {
+				return nil, unsupported("REGEX with a variable pattern or flags")
+			}
+			strs[i] = node.term.Value()
+		}
+
+		filter, err := Regex(args[0].value, strs[0], strs[1])
+		if err != nil {
+			return nil, err
+		}
+		return &sparqlExpression{filter: filter}, nil
+	default:
+		return nil, unsupported("function " + name)
+	}
+}
+
+// skip advances past whitespace and comments
+func (p *sparqlParser) skip() {
+	for p.pos < len(p.input) {
+		c := p.input[p.pos]
+		if c == '#' {
+			if end := strings.IndexByte(p.input[p.pos:], '\n'); end == -1 {
+				p.pos = len(p.input)
+			} else {
+				p.pos += end + 1
+			}
+		} else if c == ' ' || c == '\t' || c == '\r' || c == '\n' {
+			p.pos++
+		} else {
+			return
+		}
+	}
+}
+
+func (p *sparqlParser) peek() byte { return p.peekAt(0) }
+
+func (p *sparqlParser) peekAt(i int) byte {
+	if p.pos+i < len(p.input) {
+		return p.input[p.pos+i]
+	}
+	return 0
+}
+
+func (p *sparqlParser) next() rune {
+	r, size := utf8.DecodeRuneInString(p.input[p.pos:])
+	p.pos += size
+	return r
+}
+
+func (p *sparqlParser) consume(s string) bool {
+	if strings.HasPrefix(p.input[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+// rest returns the next few characters of the input for error messages
+func (p *sparqlParser) rest() string {
+	rest := p.input[p.pos:]
+	if end := strings.IndexAny(rest, " \t\r\n"); end > 0 {
+		rest = rest[:end]
+	}
+	if len(rest) > 20 {
+		rest = rest[:20]
+	}
+	return rest
+}
+
+// peekWord returns the letters at the current position without consuming them
+func (p *sparqlParser) peekWord() string {
+	end := p.pos
+	for end < len(p.input) && ('a' <= p.input[end] && p.input[end] <= 'z' || 'A' <= p.input[end] && p.input[end] <= 'Z') {
+		end++
+	}
+	if end < len(p.input) && (p.input[end] == ':' || isNameChar(p.input[end])) {
+		return ""
+	}
+	return p.input[p.pos:end]
+}
+
+// readName consumes a run of name characters, like a prefix,
+// a variable name, or a blank node label
+func (p *sparqlParser) readName() string {
+	start := p.pos
+	for p.pos < len(p.input) {
+		r, size := utf8.DecodeRuneInString(p.input[p.pos:])
+		if r == '_' || r == '-' || r == '.' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			p.pos += size
+		} else {
+			break
+		}
+	}
+
+	// Names can't end with a period
+	for p.pos > start && p.input[p.pos-1] == '.' {
+		p.pos--
+	}
+	return p.input[start:p.pos]
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || c == '-' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c >= utf8.RuneSelf
+}
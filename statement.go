@@ -23,7 +23,13 @@ func (statement *Statement) String() string {
 // URI returns the URI for the statement using path syntax
 func (statement *Statement) URI(dictionary Dictionary) string {
 	base, _ := dictionary.GetTerm(ID(statement.base), rdf.Default)
-	return fmt.Sprintf("%s/%d", base, statement.index)
+	return fmt.Sprintf("%s/%d", base.Value(), statement.index)
+}
+
+// Dataset returns the URI of the statement's dataset
+func (statement *Statement) Dataset(dictionary Dictionary) rdf.Term {
+	base, _ := dictionary.GetTerm(ID(statement.base), rdf.Default)
+	return base
 }
 
 // Graph returns the URI for the statement's graph
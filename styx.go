@@ -27,6 +27,7 @@ type Config struct {
 	TagScheme  TagScheme
 	Dictionary DictionaryFactory
 	QuadStore  QuadStore
+	Planner    Planner
 }
 
 // Close the database
@@ -79,14 +80,53 @@ func NewStore(config *Config, db *badger.DB) (*Store, error) {
 		config.QuadStore = MakeEmptyStore()
 	}
 
+	if config.Planner == nil {
+		config.Planner = CostPlanner
+	}
+
+	err := migrate(db, config)
+	if err != nil {
+		return nil, err
+	}
+
+	err = initStatistics(db)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Store{
 		Config: config,
 		Badger: db,
 	}, nil
 }
 
+// A QueryOption configures a query
+type QueryOption func(*queryOptions)
+
+type queryOptions struct {
+	filters    []*Filter
+	optionals  []optionalPattern
+	unions     [][][]*rdf.Quad
+	negations  []negation
+	paths      []*Path
+	limit      int
+	limited    bool
+	parallel   int
+	cursor     string
+	projection []rdf.Term
+	projected  bool
+	source     Source
+}
+
+// WithFilters adds filters on the values of the query's variables and blank nodes
+func WithFilters(filters ...*Filter) QueryOption {
+	return func(options *queryOptions) {
+		options.filters = append(options.filters, filters...)
+	}
+}
+
 // QueryJSONLD exposes a JSON-LD query interface
-func (s *Store) QueryJSONLD(query interface{}) (*Iterator, error) {
+func (s *Store) QueryJSONLD(query interface{}, options ...QueryOption) (*Iterator, error) {
 	opts := ld.NewJsonLdOptions("")
 	opts.ProduceGeneralizedRdf = true
 	id, err := uuid.NewRandom()
@@ -100,23 +140,67 @@ func (s *Store) QueryJSONLD(query interface{}) (*Iterator, error) {
 		return nil, err
 	}
 	quads := fromLdDataset(dataset, base)
-	return s.Query(quads, nil, nil)
+	return s.Query(quads, nil, nil, options...)
 }
 
 // Query satisfies the Styx interface
-func (s *Store) Query(pattern []*rdf.Quad, domain []rdf.Term, index []rdf.Term) (*Iterator, error) {
-	txn := s.Badger.NewTransaction(false)
-	dictionary := s.Config.Dictionary.Open(false)
-	iter, err := newIterator(pattern, domain, index, s.Config.TagScheme, txn, dictionary)
+func (s *Store) Query(pattern []*rdf.Quad, domain []rdf.Term, index []rdf.Term, options ...QueryOption) (*Iterator, error) {
+	return query(pattern, domain, index, options, s.Config.TagScheme, s.Config.Planner, func() (view, Dictionary) {
+		return badgerView{s.Badger.NewTransaction(false)}, s.Config.Dictionary.Open(false)
+	})
+}
+
+// query evaluates a pattern over the views that open returns
+func query(
+	pattern []*rdf.Quad,
+	domain []rdf.Term,
+	index []rdf.Term,
+	options []QueryOption,
+	tagScheme TagScheme,
+	planner Planner,
+	open func() (view, Dictionary),
+) (*Iterator, error) {
+	opts := &queryOptions{}
+	for _, option := range options {
+		option(opts)
+	}
+
+	fp := fingerprint(pattern, domain, opts)
+
+	var c *cursor
+	if opts.cursor != "" {
+		var err error
+		if index != nil {
+			return nil, ErrInvalidCursor
+		} else if c, domain, err = parseCursor(opts.cursor, pattern, domain, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	txn, dictionary := open()
+	iter, err := newIterator(pattern, domain, index, opts, tagScheme, planner, txn, dictionary)
 	if err != nil {
 		iter.Close()
 	}
 
-	if err == badger.ErrKeyNotFound || err == ErrEmptyInterset {
+	if err == badger.ErrKeyNotFound || err == ErrEmptyInterset || err == ErrNotFound {
 		err = nil
 		iter.top = true
 	}
 
+	if err == nil && opts.parallel > 1 {
+		err = iter.parallelize(pattern, index, opts.parallel, opts, open)
+	}
+
+	if err == nil && c != nil {
+		err = iter.resume(c)
+	}
+
+	if err == nil {
+		iter.fingerprint = fp
+		iter.limit, iter.limited = opts.limit, opts.limited
+	}
+
 	return iter, err
 }
 
@@ -141,6 +225,13 @@ func (s *Store) Log() {
 		prefix := key[0]
 		if bytes.Equal(key, SequenceKey) {
 			log.Printf("Sequence: %02d\n", binary.BigEndian.Uint64(val))
+		} else if bytes.Equal(key, StatisticsKey) {
+			st, err := getStatistics(badgerView{txn})
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			log.Printf("Statistics: %d triples, %v terms, %d graphs\n", st.triples, st.terms, st.graphs)
 		} else if prefix == ValueToIDPrefix {
 			// Value key
 			value := string(key[1:])
@@ -175,6 +266,8 @@ func (s *Store) Log() {
 				"->",
 				binary.BigEndian.Uint32(val),
 			)
+		} else if prefix == GraphPrefix {
+			log.Println("Graph entry:", string(key[1:]), "->", binary.BigEndian.Uint32(val))
 		} else if prefix == DatasetPrefix {
 			log.Printf("Dataset: %s\n", string(key[1:]))
 		} else if prefix == UnaryPrefix {
@@ -1,9 +1,14 @@
 package styx
 
 import (
+	"bytes"
 	"fmt"
 	"log"
 	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/dgraph-io/badger/v2"
@@ -55,6 +60,11 @@ func open() *Store {
 		log.Fatalln(err)
 	}
 
+	return reopen()
+}
+
+// reopen opens the database at tmpPath without clearing it
+func reopen() *Store {
 	// config := &Config{Path: tmpPath, TagScheme: tags, Dictionary: StringDictionary}
 	opt := badger.DefaultOptions(tmpPath)
 	db, err := badger.Open(opt)
@@ -81,6 +91,57 @@ func open() *Store {
 	return styx
 }
 
+// solutions returns the iterator's remaining solutions, each written
+// as the N-Quads values of its index, with unbound values as nil
+func solutions(iterator *Iterator) ([]string, error) {
+	rows := []string{}
+	for {
+		delta, err := iterator.Next(nil)
+		if err != nil {
+			return nil, err
+		} else if delta == nil {
+			return rows, nil
+		}
+		rows = append(rows, formatIndex(iterator.Index()))
+	}
+}
+
+func formatIndex(index []rdf.Term) string {
+	values := make([]string, len(index))
+	for i, term := range index {
+		if term == nil {
+			values[i] = "nil"
+		} else {
+			values[i] = term.String()
+		}
+	}
+	return strings.Join(values, " ")
+}
+
+// expectSolutions checks that the iterator's remaining solutions are exactly the expected rows.
+// If sorted is false, the rows are compared as sets, since their order depends on the dictionary.
+func expectSolutions(t *testing.T, name string, iterator *Iterator, sorted bool, expected ...string) []string {
+	t.Helper()
+	rows, err := solutions(iterator)
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return nil
+	}
+
+	actual := rows
+	if !sorted {
+		actual = append([]string{}, rows...)
+		expected = append([]string{}, expected...)
+		sort.Strings(actual)
+		sort.Strings(expected)
+	}
+
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("%s: unexpected solutions\n%s\nexpected\n%s", name, strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+	return rows
+}
+
 func TestSet(t *testing.T) {
 	styx := open()
 	defer styx.Close()
@@ -212,6 +273,97 @@ func TestOPS(t *testing.T) {
 	iterator.Log()
 }
 
+func TestJoinQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	p, q := rdf.NewNamedNode("http://example.com/p"), rdf.NewNamedNode("http://example.com/q")
+	n := make([]rdf.Term, 6)
+	for i := range n {
+		n[i] = rdf.NewNamedNode(fmt.Sprintf("http://example.com/n%d", i))
+	}
+
+	ps := [][2]int{{2, 2}, {4, 2}, {4, 0}, {5, 1}, {0, 4}}
+	qs := [][2]int{{0, 3}, {2, 3}, {1, 2}, {1, 5}, {3, 4}, {0, 1}, {0, 2}, {0, 5}, {3, 3}}
+	dataset := []*rdf.Quad{}
+	for _, e := range ps {
+		dataset = append(dataset, rdf.NewQuad(n[e[0]], p, n[e[1]], nil))
+	}
+	for _, e := range qs {
+		dataset = append(dataset, rdf.NewQuad(n[e[0]], q, n[e[1]], nil))
+	}
+
+	err := styx.Set(rdf.NewNamedNode("http://example.com/d3"), dataset)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, b, c, d := rdf.NewVariable("a"), rdf.NewVariable("b"), rdf.NewVariable("c"), rdf.NewVariable("d")
+
+	// Two unconnected triples have every pair of their solutions
+	product := [][]int{}
+	for _, e := range ps {
+		for _, f := range qs {
+			product = append(product, []int{e[0], e[1], f[0], f[1]})
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pattern  []*rdf.Quad
+		domain   []rdf.Term
+		expected [][]int
+	}{
+		{"chain", []*rdf.Quad{rdf.NewQuad(a, p, d, nil), rdf.NewQuad(c, q, a, nil)}, []rdf.Term{a, c, d}, [][]int{
+			{2, 0, 2}, {2, 1, 2}, {4, 3, 0}, {4, 3, 2}, {5, 0, 1}, {5, 1, 1},
+		}},
+		// Variables that run out of values on the way down
+		// don't leave behind a solution without a value.
+		{"cycle", []*rdf.Quad{rdf.NewQuad(a, q, c, nil), rdf.NewQuad(c, p, a, nil)}, []rdf.Term{a, c}, [][]int{
+			{1, 5},
+		}},
+		// Every later variable gets re-seeked when an earlier one
+		// changes, not just the ones that depend on it.
+		{"product", []*rdf.Quad{rdf.NewQuad(a, p, b, nil), rdf.NewQuad(c, q, d, nil)}, []rdf.Term{a, b, c, d}, product},
+	}
+
+	for _, test := range tests {
+		iterator, err := styx.Query(test.pattern, test.domain, nil)
+		if err != nil {
+			iterator.Close()
+			t.Fatal(err)
+		}
+
+		expected := map[string]bool{}
+		for _, row := range test.expected {
+			values := make([]rdf.Term, len(row))
+			for i, j := range row {
+				values[i] = n[j]
+			}
+			expected[fmt.Sprint(values)] = true
+		}
+
+		result := [][]rdf.Term{}
+		for delta, err := iterator.Next(nil); delta != nil; delta, err = iterator.Next(nil) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			result = append(result, iterator.Index())
+		}
+		iterator.Close()
+
+		if len(result) != len(expected) {
+			t.Errorf("%s: expected %d solutions, got %d", test.name, len(expected), len(result))
+		}
+
+		for _, index := range result {
+			if !expected[fmt.Sprint(index)] {
+				t.Errorf("%s: unexpected solution %v", test.name, index)
+			}
+		}
+	}
+}
+
 func TestSimpleQuery(t *testing.T) {
 	styx := open()
 	defer styx.Close()
@@ -329,3 +481,2393 @@ func TestIndexQuery(t *testing.T) {
 
 	iterator.Log()
 }
+
+func TestAllVariableQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every stored triple is a solution of a single all-variable triple
+	txn := styx.Badger.NewTransaction(false)
+	st, err := getStatistics(badgerView{txn})
+	txn.Discard()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s, p, o := rdf.NewVariable("s"), rdf.NewVariable("p"), rdf.NewVariable("o")
+	iterator, err := styx.Query([]*rdf.Quad{rdf.NewQuad(s, p, o, nil)}, nil, nil)
+	if err != nil {
+		iterator.Close()
+		t.Error(err)
+		return
+	}
+
+	rows, err := solutions(iterator)
+	iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	} else if st.triples != 14 || len(rows) != int(st.triples) {
+		t.Errorf("Unexpected number of solutions: %d of %d triples", len(rows), st.triples)
+	}
+
+	// Joining the all-variable triple with a second triple
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, p, o, nil),
+		rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/name"), rdf.NewLiteral("Jane Doe", "", nil), nil),
+	}
+
+	iterator, err = styx.Query(pattern, []rdf.Term{s, p, o}, nil)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rows = expectSolutions(t, "join", iterator, false,
+		"<http://people.com/jane> <http://www.w3.org/1999/02/22-rdf-syntax-ns#type> <http://schema.org/Person>",
+		"<http://people.com/jane> <http://schema.org/birthDate> \"1995-01-01\"^^<http://www.w3.org/2001/XMLSchema#date>",
+		"<http://people.com/jane> <http://schema.org/name> \"Jane Doe\"",
+		"<http://people.com/jane> <http://schema.org/familyName> \"Doe\"@en",
+	)
+
+	// Seeking to a solution in the middle of the results resumes there
+	if len(rows) == 4 {
+		err = iterator.Seek(nil)
+		for i := 0; i < 2 && err == nil; i++ {
+			_, err = iterator.Next(nil)
+		}
+
+		if err == nil {
+			err = iterator.Seek(iterator.Index())
+		}
+
+		if err != nil {
+			t.Error(err)
+		} else if tail, err := solutions(iterator); err != nil {
+			t.Error(err)
+		} else if strings.Join(tail, "\n") != strings.Join(rows[1:], "\n") {
+			t.Error("Unexpected solutions after seeking", tail)
+		}
+	}
+
+	// Repeated variables only match triples with the same term in their places
+	a, b, c := rdf.NewNamedNode("http://example.com/a"), rdf.NewNamedNode("http://example.com/b"), rdf.NewNamedNode("http://example.com/c")
+	q, r := rdf.NewNamedNode("http://example.com/q"), rdf.NewNamedNode("http://example.com/r")
+	err = styx.Set(rdf.NewNamedNode("http://example.com/d3"), []*rdf.Quad{
+		rdf.NewQuad(a, r, a, nil),
+		rdf.NewQuad(a, q, b, nil),
+		rdf.NewQuad(b, q, a, nil),
+		rdf.NewQuad(c, c, c, nil),
+		rdf.NewQuad(c, q, c, nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		pattern  []*rdf.Quad
+		domain   []rdf.Term
+		expected []string
+	}{
+		{"subject and object", []*rdf.Quad{rdf.NewQuad(s, p, s, nil)}, []rdf.Term{s, p}, []string{
+			"<http://example.com/a> <http://example.com/r>", "<http://example.com/c> <http://example.com/c>", "<http://example.com/c> <http://example.com/q>",
+		}},
+		{"object first", []*rdf.Quad{rdf.NewQuad(s, p, s, nil)}, []rdf.Term{p, s}, []string{
+			"<http://example.com/r> <http://example.com/a>", "<http://example.com/c> <http://example.com/c>", "<http://example.com/q> <http://example.com/c>",
+		}},
+		{"subject and predicate", []*rdf.Quad{rdf.NewQuad(s, s, o, nil)}, []rdf.Term{s, o}, []string{
+			"<http://example.com/c> <http://example.com/c>",
+		}},
+		{"predicate and object", []*rdf.Quad{rdf.NewQuad(s, o, o, nil)}, []rdf.Term{o, s}, []string{
+			"<http://example.com/c> <http://example.com/c>",
+		}},
+		{"every place", []*rdf.Quad{rdf.NewQuad(s, s, s, nil)}, []rdf.Term{s}, []string{
+			"<http://example.com/c>",
+		}},
+		{"join", []*rdf.Quad{rdf.NewQuad(s, p, s, nil), rdf.NewQuad(s, q, o, nil)}, []rdf.Term{o, s, p}, []string{
+			"<http://example.com/b> <http://example.com/a> <http://example.com/r>", "<http://example.com/c> <http://example.com/c> <http://example.com/c>", "<http://example.com/c> <http://example.com/c> <http://example.com/q>",
+		}},
+	}
+
+	for _, test := range tests {
+		iterator, err := styx.Query(test.pattern, test.domain, nil)
+		if err != nil {
+			iterator.Close()
+			t.Fatal(err)
+		}
+		expectSolutions(t, test.name, iterator, false, test.expected...)
+		iterator.Close()
+	}
+
+	// Repeated variables in a named graph are not supported
+	iterator, err = styx.Query([]*rdf.Quad{rdf.NewQuad(s, p, s, rdf.NewVariable("g"))}, nil, nil)
+	iterator.Close()
+	if err == nil || !strings.Contains(err.Error(), "repeated") {
+		t.Error("Expected an error for repeated variables in a named graph", err)
+	}
+}
+
+func TestGraphQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, n, g := rdf.NewVariable("s"), rdf.NewVariable("n"), rdf.NewVariable("g")
+	name := rdf.NewNamedNode("http://schema.org/name")
+	generatedAtTime := rdf.NewNamedNode("http://www.w3.org/ns/prov#generatedAtTime")
+
+	// The names in the first dataset are in its named graph, and the
+	// second dataset's name is in its default graph, which is <d2#>.
+	tests := []struct {
+		name     string
+		pattern  []*rdf.Quad
+		domain   []rdf.Term
+		expected []string
+	}{
+		{"graph variable", []*rdf.Quad{rdf.NewQuad(s, name, n, g)}, []rdf.Term{g, s, n}, []string{
+			"<http://example.com/d1#b0> <http://example.com/d1#b1> \"John Doe\"",
+			"<http://example.com/d1#b0> <http://example.com/d1#b1> \"Johnny Doe\"",
+			"<http://example.com/d1#b0> <http://people.com/jane> \"Jane Doe\"",
+			"<http://example.com/d2#> <http://example.com/d2#b0> \"Johnanthan Appleseed\"",
+		}},
+		{"default graph", []*rdf.Quad{rdf.NewQuad(s, generatedAtTime, n, g)}, []rdf.Term{g, s, n}, []string{
+			"<http://example.com/d1#> <http://example.com/d1#b0> \"2019-07-24T16:46:05.751Z\"^^<http://www.w3.org/2001/XMLSchema#dateTime>",
+		}},
+		{"named graph", []*rdf.Quad{rdf.NewQuad(s, name, n, rdf.NewNamedNode(d1+"#b0"))}, []rdf.Term{s, n}, []string{
+			"<http://example.com/d1#b1> \"John Doe\"",
+			"<http://example.com/d1#b1> \"Johnny Doe\"",
+			"<http://people.com/jane> \"Jane Doe\"",
+		}},
+		{"default graph IRI", []*rdf.Quad{rdf.NewQuad(s, name, n, rdf.NewNamedNode(d2+"#"))}, []rdf.Term{s, n}, []string{
+			"<http://example.com/d2#b0> \"Johnanthan Appleseed\"",
+		}},
+		{"other graph", []*rdf.Quad{rdf.NewQuad(s, generatedAtTime, n, rdf.NewNamedNode(d1+"#b0"))}, []rdf.Term{s, n}, nil},
+	}
+
+	for _, test := range tests {
+		iterator, err := styx.Query(test.pattern, test.domain, nil)
+		if err != nil {
+			t.Error(err)
+		} else {
+			expectSolutions(t, test.name, iterator, false, test.expected...)
+		}
+		iterator.Close()
+	}
+}
+
+func TestFilterQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A literal with a datatype that can't be ordered
+	a := rdf.NewNamedNode("http://example.com/a")
+	foo := rdf.NewLiteral("foo", "", rdf.NewNamedNode("http://example.com/t"))
+	err = styx.Set(rdf.NewNamedNode("http://example.com/d3"), []*rdf.Quad{
+		rdf.NewQuad(a, rdf.NewNamedNode("http://example.com/p"), foo, nil),
+		rdf.NewQuad(a, rdf.NewNamedNode("http://example.com/q"), rdf.NewLiteral("foo", "", nil), nil),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, p, o := rdf.NewVariable("s"), rdf.NewVariable("p"), rdf.NewVariable("o")
+	n, d := rdf.NewVariable("n"), rdf.NewVariable("d")
+	name := rdf.NewNamedNode("http://schema.org/name")
+	jane := rdf.NewNamedNode("http://people.com/jane")
+	names := []*rdf.Quad{rdf.NewQuad(s, name, n, nil)}
+	birthDates := []*rdf.Quad{
+		rdf.NewQuad(s, name, n, nil),
+		rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/birthDate"), d, nil),
+	}
+
+	xsdDate := rdf.NewNamedNode("http://www.w3.org/2001/XMLSchema#date")
+	xsdInteger := rdf.NewNamedNode("http://www.w3.org/2001/XMLSchema#integer")
+	mustFilter := func(filter *Filter, err error) *Filter {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filter
+	}
+
+	after := mustFilter(Compare(Node(d), ">", Node(rdf.NewLiteral("1990-01-01", "", xsdDate))))
+	john := mustFilter(Regex(Node(n), "^john", "i"))
+	one, two := Node(rdf.NewLiteral("1", "", xsdInteger)), Node(rdf.NewLiteral("2", "", xsdInteger))
+
+	var (
+		johnDoe    = "<http://example.com/d1#b1> \"John Doe\""
+		johnnyDoe  = "<http://example.com/d1#b1> \"Johnny Doe\""
+		janeDoe    = "<http://people.com/jane> \"Jane Doe\""
+		johnanthan = "<http://example.com/d2#b0> \"Johnanthan Appleseed\""
+		date1996   = " \"1996-02-02\"^^<http://www.w3.org/2001/XMLSchema#date>"
+		date1995   = " \"1995-01-01\"^^<http://www.w3.org/2001/XMLSchema#date>"
+	)
+
+	tests := []struct {
+		name     string
+		pattern  []*rdf.Quad
+		domain   []rdf.Term
+		filter   *Filter
+		expected []string
+	}{
+		{"compare", birthDates, []rdf.Term{s, n, d}, after, []string{johnDoe + date1996, johnnyDoe + date1996, janeDoe + date1995}},
+		{"regex", names, []rdf.Term{s, n}, john, []string{johnDoe, johnnyDoe, johnanthan}},
+		{"lang", []*rdf.Quad{rdf.NewQuad(jane, p, o, nil)}, []rdf.Term{p, o},
+			mustFilter(Compare(Lang(Node(o)), "=", Node(rdf.NewLiteral("en", "", nil)))),
+			[]string{"<http://schema.org/familyName> \"Doe\"@en"}},
+		{"datatype", []*rdf.Quad{rdf.NewQuad(jane, p, o, nil)}, []rdf.Term{p, o},
+			mustFilter(Compare(Datatype(Node(o)), "=", Node(xsdDate))),
+			[]string{"<http://schema.org/birthDate> \"1995-01-01\"^^<http://www.w3.org/2001/XMLSchema#date>"}},
+		{"not", names, []rdf.Term{s, n}, Not(john), []string{janeDoe}},
+		{"and", birthDates, []rdf.Term{s, n, d}, And(john, after), []string{johnDoe + date1996, johnnyDoe + date1996}},
+		{"or", names, []rdf.Term{s, n},
+			Or(mustFilter(Regex(Node(n), "^jane", "i")), mustFilter(Regex(Node(n), "appleseed$", "i"))),
+			[]string{janeDoe, johnanthan}},
+		{"constant true", names, []rdf.Term{s, n}, mustFilter(Compare(one, "<", two)),
+			[]string{johnDoe, johnnyDoe, janeDoe, johnanthan}},
+		{"constant false", names, []rdf.Term{s, n}, mustFilter(Compare(one, ">", two)), nil},
+		{"str", []*rdf.Quad{rdf.NewQuad(a, p, o, nil)}, []rdf.Term{p, o},
+			mustFilter(Compare(Str(Node(o)), "=", Node(o))),
+			[]string{"<http://example.com/q> \"foo\""}},
+		{"unordered", []*rdf.Quad{rdf.NewQuad(a, p, o, nil)}, []rdf.Term{p, o},
+			mustFilter(Compare(Node(rdf.NewLiteral("foo", "", nil)), "!=", Node(o))),
+			[]string{"<http://example.com/p> \"foo\"^^<http://example.com/t>"}},
+	}
+
+	for _, test := range tests {
+		iterator, err := styx.Query(test.pattern, test.domain, nil, WithFilters(test.filter))
+		if err != nil {
+			t.Error(err)
+		} else {
+			expectSolutions(t, test.name, iterator, false, test.expected...)
+		}
+		iterator.Close()
+	}
+
+	// Seeking past the last name of the first subject and the first person
+	// moves on to the next person, and not to the next subject, since the
+	// names don't depend on the person.
+	person := rdf.NewVariable("person")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, name, n, nil),
+		rdf.NewQuad(person, rdf.NewNamedNode("http://www.w3.org/1999/02/22-rdf-syntax-ns#type"), rdf.NewNamedNode("http://schema.org/Person"), nil),
+	}
+
+	iterator, err := styx.Query(pattern, []rdf.Term{s, person, n}, nil, WithFilters(john))
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if _, err = iterator.Next(nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	first := iterator.Index()
+	prefix := formatIndex(first[:2])
+	if err = iterator.Seek(nil); err != nil {
+		t.Error(err)
+		return
+	}
+
+	rows, err := solutions(iterator)
+	if err != nil {
+		t.Error(err)
+		return
+	} else if len(rows) != 9 {
+		t.Error("Unexpected solutions", rows)
+		return
+	}
+
+	// The IRI dictionary orders plain literals by their lexical
+	// form, so "Zoe" is after every one of the names.
+	expected := []string{}
+	for _, row := range rows {
+		if !strings.HasPrefix(row, prefix+" ") {
+			expected = append(expected, row)
+		}
+	}
+
+	err = iterator.Seek([]rdf.Term{first[0], first[1], rdf.NewLiteral("Zoe", "", nil)})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	tail, err := solutions(iterator)
+	if err != nil {
+		t.Error(err)
+	} else if strings.Join(tail, "\n") != strings.Join(expected, "\n") {
+		t.Error("Unexpected solutions after seeking", tail)
+	}
+}
+
+func TestRangeQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, d := rdf.NewVariable("s"), rdf.NewVariable("d")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/birthDate"), d, nil),
+	}
+
+	xsdDate := rdf.NewNamedNode("http://www.w3.org/2001/XMLSchema#date")
+	after, _ := Compare(Node(d), ">=", Node(rdf.NewLiteral("1700-01-01", "", xsdDate)))
+	before, _ := Compare(Node(d), "<", Node(rdf.NewLiteral("1996-01-01", "", xsdDate)))
+
+	iterator, err := styx.Query(pattern, []rdf.Term{d, s}, nil, WithFilters(And(after, before)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Dates are ordered by value
+	expectSolutions(t, "dates", iterator, true,
+		"\"1780-01-10\"^^<http://www.w3.org/2001/XMLSchema#date> <http://example.com/d2#b0>",
+		"\"1995-01-01\"^^<http://www.w3.org/2001/XMLSchema#date> <http://people.com/jane>",
+	)
+	iterator.Close()
+
+	// Integers, decimals, and doubles are all ordered together by value
+	xsd := "http://www.w3.org/2001/XMLSchema#"
+	values := map[string]*rdf.Literal{
+		"a": rdf.NewLiteral("-5", "", rdf.NewNamedNode(xsd+"integer")),
+		"b": rdf.NewLiteral("-2.5", "", rdf.NewNamedNode(xsd+"decimal")),
+		"c": rdf.NewLiteral("-1.5E0", "", rdf.NewNamedNode(xsd+"double")),
+		"d": rdf.NewLiteral("0", "", rdf.NewNamedNode(xsd+"integer")),
+		"e": rdf.NewLiteral("3", "", rdf.NewNamedNode(xsd+"integer")),
+		"f": rdf.NewLiteral("3.0", "", rdf.NewNamedNode(xsd+"decimal")),
+		"g": rdf.NewLiteral("3.5", "", rdf.NewNamedNode(xsd+"decimal")),
+		"h": rdf.NewLiteral("1.0E1", "", rdf.NewNamedNode(xsd+"double")),
+		"i": rdf.NewLiteral("200", "", rdf.NewNamedNode(xsd+"integer")),
+	}
+
+	value := rdf.NewNamedNode("http://example.com/value")
+	dataset := []*rdf.Quad{}
+	for name, literal := range values {
+		dataset = append(dataset, rdf.NewQuad(rdf.NewNamedNode("http://example.com/"+name), value, literal, nil))
+	}
+
+	err = styx.Set(rdf.NewNamedNode("http://example.com/d3"), dataset)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	v := rdf.NewVariable("v")
+	three := Node(rdf.NewLiteral("3", "", rdf.NewNamedNode(xsd+"integer")))
+	mustCompare := func(op string, b Expression) *Filter {
+		filter, err := Compare(Node(v), op, b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filter
+	}
+
+	tests := []struct {
+		filter   *Filter
+		sorted   bool
+		expected string
+	}{
+		{mustCompare("<", three), false, "abcd"},
+		{mustCompare("<=", three), false, "abcdef"},
+		{mustCompare(">", three), true, "ghi"},
+		{mustCompare(">=", three), false, "efghi"},
+		{mustCompare(">", Node(rdf.NewLiteral("-2.0E0", "", rdf.NewNamedNode(xsd+"double")))), false, "cdefghi"},
+		{mustCompare("<", Node(rdf.NewLiteral("-3", "", rdf.NewNamedNode(xsd+"integer")))), true, "a"},
+		{And(mustCompare(">=", Node(rdf.NewLiteral("-2.5", "", rdf.NewNamedNode(xsd+"decimal")))), mustCompare("<", three)), true, "bcd"},
+	}
+
+	for i, test := range tests {
+		iterator, err := styx.Query([]*rdf.Quad{rdf.NewQuad(s, value, v, nil)}, []rdf.Term{v, s}, nil, WithFilters(test.filter))
+		if err != nil {
+			t.Error(err)
+		} else {
+			expected := make([]string, len(test.expected))
+			for j, name := range test.expected {
+				expected[j] = values[string(name)].String() + " <http://example.com/" + string(name) + ">"
+			}
+			expectSolutions(t, fmt.Sprintf("range %d", i), iterator, test.sorted, expected...)
+		}
+		iterator.Close()
+	}
+}
+
+// downgrade rewrites the ordered literal IDs in a store's indices and quad store
+// in the encoding from before literals were ordered by value, where the IDs of
+// all literals started with a quote, and removes the version of the encoding.
+func downgrade(s *Store) (err error) {
+	convert := func(id ID) ID {
+		if len(id) < 2 || id[0] != orderedPrefix {
+			return id
+		}
+		tail := string(id[2+orderedWidths[id[1]]:])
+		i := strings.IndexByte(tail, '"')
+		return ID("\"" + tail[i+1:] + "\":" + tail[:i])
+	}
+
+	prefixes := append([]byte{UnaryPrefix}, TernaryPrefixes[:]...)
+	prefixes = append(prefixes, BinaryPrefixes[:]...)
+
+	read := s.Badger.NewTransaction(false)
+	defer read.Discard()
+
+	txn := s.Badger.NewTransaction(true)
+	defer func() { txn.Discard() }()
+
+	for _, prefix := range prefixes {
+		iter := read.NewIterator(badger.IteratorOptions{Prefix: []byte{prefix}})
+		for iter.Rewind(); iter.Valid() && err == nil; iter.Next() {
+			key := iter.Item().KeyCopy(nil)
+			terms := strings.Split(string(key[1:]), "\t")
+			ids := make([]ID, len(terms))
+			for i, term := range terms {
+				ids[i] = convert(ID(term))
+			}
+
+			old := assembleKey(prefix, false, ids...)
+			if bytes.Equal(old, key) {
+				continue
+			}
+
+			var val []byte
+			if val, err = iter.Item().ValueCopy(nil); err == nil {
+				if txn, err = setSafe(old, val, txn, s.Badger); err == nil {
+					txn, err = deleteSafe(key, txn, s.Badger)
+				}
+			}
+		}
+		iter.Close()
+		if err != nil {
+			return
+		}
+	}
+
+	list := s.Config.QuadStore.List(NIL)
+	origins := []ID{}
+	for id, valid := list.Next(); valid; id, valid = list.Next() {
+		origins = append(origins, id)
+	}
+	list.Close()
+
+	for _, origin := range origins {
+		quads, err := s.Config.QuadStore.Get(origin)
+		if err != nil {
+			return err
+		}
+		for _, quad := range quads {
+			for i, id := range quad {
+				quad[i] = convert(id)
+			}
+		}
+		if err = s.Config.QuadStore.Set(origin, quads); err != nil {
+			return err
+		}
+	}
+
+	if txn, err = deleteSafe(EncodingKey, txn, s.Badger); err != nil {
+		return
+	}
+	return txn.Commit()
+}
+
+func TestMigrate(t *testing.T) {
+	styx := open()
+
+	xsd := "http://www.w3.org/2001/XMLSchema#"
+	s, p, o := rdf.NewVariable("s"), rdf.NewVariable("p"), rdf.NewVariable("o")
+	value := rdf.NewNamedNode("http://example.com/value")
+	dataset := []*rdf.Quad{
+		rdf.NewQuad(rdf.NewNamedNode("http://example.com/a"), value, rdf.NewLiteral("-1.5", "", rdf.NewNamedNode(xsd+"decimal")), nil),
+		rdf.NewQuad(rdf.NewNamedNode("http://example.com/b"), value, rdf.NewLiteral("4", "", rdf.NewNamedNode(xsd+"integer")), nil),
+		rdf.NewQuad(rdf.NewNamedNode("http://example.com/c"), value, rdf.NewLiteral("1.0E1", "", rdf.NewNamedNode(xsd+"double")), nil),
+	}
+
+	datasets := []rdf.Term{rdf.NewNamedNode(d1), rdf.NewNamedNode(d2), rdf.NewNamedNode("http://example.com/d3")}
+	err := styx.SetJSONLD(d1, document1, false)
+	if err == nil {
+		err = styx.SetJSONLD(d2, document2, false)
+	}
+	if err == nil {
+		err = styx.Set(datasets[2], dataset)
+	}
+	if err != nil {
+		styx.Close()
+		t.Fatal(err)
+	}
+
+	positive, _ := Compare(Node(o), ">", Node(rdf.NewLiteral("0", "", rdf.NewNamedNode(xsd+"integer"))))
+	before, _ := Compare(Node(o), "<", Node(rdf.NewLiteral("1990-01-01", "", rdf.NewNamedNode(xsd+"date"))))
+	queries := []struct {
+		pattern []*rdf.Quad
+		domain  []rdf.Term
+		filter  *Filter
+	}{
+		{[]*rdf.Quad{rdf.NewQuad(s, p, o, nil)}, []rdf.Term{s, p, o}, nil},
+		{[]*rdf.Quad{rdf.NewQuad(s, value, o, nil)}, []rdf.Term{o, s}, positive},
+		{[]*rdf.Quad{rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/birthDate"), o, nil)}, []rdf.Term{o, s}, before},
+	}
+
+	// snapshot returns the store's datasets and the solutions of the queries
+	snapshot := func(styx *Store) []string {
+		results := []string{}
+		for _, dataset := range datasets {
+			quads, err := styx.Get(dataset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, quad := range quads {
+				results = append(results, quad.String())
+			}
+		}
+
+		for _, query := range queries {
+			var options []QueryOption
+			if query.filter != nil {
+				options = append(options, WithFilters(query.filter))
+			}
+			iterator, err := styx.Query(query.pattern, query.domain, nil, options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rows, err := solutions(iterator)
+			iterator.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(rows)
+			results = append(results, fmt.Sprintf("%d solutions", len(rows)))
+			results = append(results, rows...)
+		}
+		return results
+	}
+
+	// ordered returns true if the unary index has the literals' IDs in the ordered encoding
+	ordered := func(styx *Store) bool {
+		dictionary := styx.Config.Dictionary.Open(false)
+		defer dictionary.Commit()
+		txn := styx.Badger.NewTransaction(false)
+		defer txn.Discard()
+		for _, quad := range dataset {
+			id, err := dictionary.GetID(quad[2], rdf.Default)
+			if err != nil || id[0] != orderedPrefix {
+				t.Fatal("Unexpected literal ID", id, err)
+			} else if _, err := txn.Get(assembleKey(UnaryPrefix, false, id)); err != nil {
+				return false
+			}
+		}
+		return true
+	}
+
+	// entries returns the keys and values of the store's indices
+	entries := func(styx *Store) map[string]string {
+		result := map[string]string{}
+		txn := styx.Badger.NewTransaction(false)
+		defer txn.Discard()
+		prefixes := append([]byte{UnaryPrefix}, TernaryPrefixes[:]...)
+		for _, prefix := range append(prefixes, BinaryPrefixes[:]...) {
+			iter := txn.NewIterator(badger.IteratorOptions{Prefix: []byte{prefix}})
+			for iter.Rewind(); iter.Valid(); iter.Next() {
+				value, err := iter.Item().ValueCopy(nil)
+				if err != nil {
+					iter.Close()
+					t.Fatal(err)
+				}
+				result[string(iter.Item().Key())] = string(value)
+			}
+			iter.Close()
+		}
+		return result
+	}
+
+	expected, migrated := snapshot(styx), entries(styx)
+	if err := downgrade(styx); err != nil {
+		styx.Close()
+		t.Fatal(err)
+	} else if ordered(styx) {
+		styx.Close()
+		t.Fatal("Expected literal IDs in the old encoding")
+	}
+
+	// The entries that only exist in the old encoding
+	stale := map[string]string{}
+	for key, value := range entries(styx) {
+		if _, has := migrated[key]; !has {
+			stale[key] = value
+		}
+	}
+	styx.Close()
+
+	styx = reopen()
+	if !ordered(styx) {
+		t.Error("Expected literal IDs in the ordered encoding")
+	}
+
+	actual := snapshot(styx)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected results after migrating\n%s\nexpected\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+
+	// A migration that failed partway, after writing some of the new keys but
+	// before deleting the old ones, gets finished the next time the store opens
+	if len(stale) == 0 {
+		styx.Close()
+		t.Fatal("Expected index keys in the old encoding")
+	}
+
+	err = styx.Badger.Update(func(txn *badger.Txn) error {
+		i := 0
+		for key, value := range stale {
+			if i++; i%2 == 0 {
+				continue
+			} else if err := txn.SetEntry(badger.NewEntry([]byte(key), []byte(value)).WithMeta(key[0])); err != nil {
+				return err
+			}
+		}
+		return txn.Delete(EncodingKey)
+	})
+	styx.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	styx = reopen()
+	defer styx.Close()
+
+	if actual := entries(styx); !reflect.DeepEqual(actual, migrated) {
+		t.Errorf("Unexpected index entries after migrating again: %d, expected %d", len(actual), len(migrated))
+	}
+
+	actual = snapshot(styx)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected results after migrating again\n%s\nexpected\n%s", strings.Join(actual, "\n"), strings.Join(expected, "\n"))
+	}
+}
+
+func TestOptionalQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, n, f := rdf.NewVariable("s"), rdf.NewVariable("n"), rdf.NewVariable("f")
+	k, kn := rdf.NewVariable("k"), rdf.NewVariable("kn")
+	name := rdf.NewNamedNode("http://schema.org/name")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, rdf.NewNamedNode("http://www.w3.org/1999/02/22-rdf-syntax-ns#type"), rdf.NewNamedNode("http://schema.org/Person"), nil),
+	}
+
+	names := []*rdf.Quad{rdf.NewQuad(s, name, n, nil)}
+	familyName := []*rdf.Quad{rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/familyName"), f, nil)}
+	knows := []*rdf.Quad{rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/knows"), k, nil)}
+	knownName := []*rdf.Quad{rdf.NewQuad(k, name, kn, nil)}
+
+	var (
+		b1   = "<http://example.com/d1#b1>"
+		jane = "<http://people.com/jane>"
+		b0   = "<http://example.com/d2#b0>"
+	)
+
+	tests := []struct {
+		name      string
+		optionals []QueryOption
+		index     []rdf.Term
+		expected  []string
+	}{
+		{"optional", []QueryOption{WithOptional(familyName)}, nil, []string{
+			b1 + " nil",
+			jane + ` "Doe"@en`,
+			b0 + " nil",
+		}},
+		{"optionals", []QueryOption{WithOptional(names), WithOptional(familyName)}, nil, []string{
+			b1 + ` "John Doe" nil`,
+			b1 + ` "Johnny Doe" nil`,
+			jane + ` "Jane Doe" "Doe"@en`,
+			b0 + ` "Johnanthan Appleseed" nil`,
+		}},
+		// The second group sees the value that the first group bound to k,
+		// and Jane doesn't know anyone, so her k is joined with every name.
+		{"chained optionals", []QueryOption{WithOptional(knows), WithOptional(knownName)}, nil, []string{
+			b1 + " " + jane + ` "Jane Doe"`,
+			jane + " " + b1 + ` "John Doe"`,
+			jane + " " + b1 + ` "Johnny Doe"`,
+			jane + " " + jane + ` "Jane Doe"`,
+			jane + " " + b0 + ` "Johnanthan Appleseed"`,
+			b0 + " " + jane + ` "Jane Doe"`,
+		}},
+		{"seek", []QueryOption{WithOptional(names), WithOptional(familyName)}, []rdf.Term{rdf.NewNamedNode("http://people.com/jane")}, []string{
+			jane + ` "Jane Doe" "Doe"@en`,
+			b0 + ` "Johnanthan Appleseed" nil`,
+		}},
+		{"seek unbound", []QueryOption{WithOptional(familyName)}, []rdf.Term{rdf.NewNamedNode(d2 + "#b0")}, []string{
+			b0 + " nil",
+		}},
+	}
+
+	for _, test := range tests {
+		iterator, err := styx.Query(pattern, []rdf.Term{s}, nil, test.optionals...)
+		if err == nil && test.index != nil {
+			err = iterator.Seek(test.index)
+		}
+
+		if err != nil {
+			t.Error(err)
+		} else {
+			expectSolutions(t, test.name, iterator, true, test.expected...)
+		}
+		iterator.Close()
+	}
+}
+
+func TestUnionQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, n, k := rdf.NewVariable("s"), rdf.NewVariable("n"), rdf.NewVariable("k")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, rdf.NewNamedNode("http://www.w3.org/1999/02/22-rdf-syntax-ns#type"), rdf.NewNamedNode("http://schema.org/Person"), nil),
+	}
+
+	name := []*rdf.Quad{rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/name"), n, nil)}
+	familyName := []*rdf.Quad{rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/familyName"), n, nil)}
+	knows := []*rdf.Quad{rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/knows"), k, nil)}
+	nameAndKnows := []*rdf.Quad{name[0], rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/knows"), rdf.NewBlankNode("k"), nil)}
+
+	var (
+		b1   = rdf.NewNamedNode(d1 + "#b1")
+		jane = rdf.NewNamedNode("http://people.com/jane")
+		b0   = rdf.NewNamedNode(d2 + "#b0")
+	)
+
+	// Unbound values sort before every bound value
+	tests := []struct {
+		name     string
+		domain   []rdf.Term
+		union    QueryOption
+		index    []rdf.Term
+		expected []string
+	}{
+		{"union", []rdf.Term{s, n}, WithUnion(name, familyName), nil, []string{
+			b1.String() + ` "John Doe"`,
+			b1.String() + ` "Johnny Doe"`,
+			jane.String() + ` "Doe"@en`,
+			jane.String() + ` "Jane Doe"`,
+			b0.String() + ` "Johnanthan Appleseed"`,
+		}},
+		{"duplicate alternatives", []rdf.Term{s, n}, WithUnion(name, name), nil, []string{
+			b1.String() + ` "John Doe"`,
+			b1.String() + ` "Johnny Doe"`,
+			jane.String() + ` "Jane Doe"`,
+			b0.String() + ` "Johnanthan Appleseed"`,
+		}},
+		// Solutions of more than one alternative are only returned once
+		{"overlapping alternatives", []rdf.Term{s, n}, WithUnion(nameAndKnows, name), nil, []string{
+			b1.String() + ` "John Doe"`,
+			b1.String() + ` "Johnny Doe"`,
+			jane.String() + ` "Jane Doe"`,
+			b0.String() + ` "Johnanthan Appleseed"`,
+		}},
+		{"different variables", []rdf.Term{s, n, k}, WithUnion(name, knows), nil, []string{
+			b1.String() + " nil " + jane.String(),
+			b1.String() + ` "John Doe" nil`,
+			b1.String() + ` "Johnny Doe" nil`,
+			jane.String() + ` "Jane Doe" nil`,
+			b0.String() + " nil " + jane.String(),
+			b0.String() + ` "Johnanthan Appleseed" nil`,
+		}},
+		{"seek", []rdf.Term{s, n, k}, WithUnion(name, knows), []rdf.Term{b1, rdf.NewLiteral("Johnny Doe", "", nil)}, []string{
+			b1.String() + ` "Johnny Doe" nil`,
+			jane.String() + ` "Jane Doe" nil`,
+			b0.String() + " nil " + jane.String(),
+			b0.String() + ` "Johnanthan Appleseed" nil`,
+		}},
+		{"seek past a branch", []rdf.Term{s, n, k}, WithUnion(name, knows), []rdf.Term{b1, rdf.NewLiteral("Zoe", "", nil)}, []string{
+			jane.String() + ` "Jane Doe" nil`,
+			b0.String() + " nil " + jane.String(),
+			b0.String() + ` "Johnanthan Appleseed" nil`,
+		}},
+	}
+
+	for _, test := range tests {
+		iterator, err := styx.Query(pattern, test.domain, nil, test.union)
+		if err == nil && test.index != nil {
+			err = iterator.Seek(test.index)
+		}
+
+		if err != nil {
+			t.Error(err)
+		} else {
+			expectSolutions(t, test.name, iterator, true, test.expected...)
+		}
+		iterator.Close()
+	}
+}
+
+func TestNotExistsQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, f := rdf.NewVariable("s"), rdf.NewVariable("f")
+	x, y := rdf.NewVariable("x"), rdf.NewVariable("y")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, rdf.NewNamedNode("http://www.w3.org/1999/02/22-rdf-syntax-ns#type"), rdf.NewNamedNode("http://schema.org/Person"), nil),
+	}
+
+	familyName := []*rdf.Quad{rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/familyName"), f, nil)}
+	knowsJane := []*rdf.Quad{rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/knows"), rdf.NewNamedNode("http://people.com/jane"), nil)}
+	disjoint := []*rdf.Quad{rdf.NewQuad(x, rdf.NewNamedNode("http://schema.org/name"), y, nil)}
+	missing := []*rdf.Quad{rdf.NewQuad(x, rdf.NewNamedNode("http://schema.org/nothing"), y, nil)}
+
+	var (
+		b1   = "<http://example.com/d1#b1>"
+		jane = "<http://people.com/jane>"
+		b0   = "<http://example.com/d2#b0>"
+	)
+
+	tests := []struct {
+		name     string
+		option   QueryOption
+		expected []string
+	}{
+		{"not exists", WithNotExists(familyName), []string{b1, b0}},
+		{"minus", WithMinus(familyName), []string{b1, b0}},
+		{"not exists constant", WithNotExists(knowsJane), []string{jane}},
+		{"minus constant", WithMinus(knowsJane), []string{jane}},
+		// A pattern that doesn't share any variables with the query always
+		// exists if it has any solutions, but MINUS never removes anything
+		// that it isn't compatible with.
+		{"not exists disjoint", WithNotExists(disjoint), nil},
+		{"minus disjoint", WithMinus(disjoint), []string{b1, jane, b0}},
+		{"not exists empty", WithNotExists(missing), []string{b1, jane, b0}},
+	}
+
+	for _, test := range tests {
+		iterator, err := styx.Query(pattern, []rdf.Term{s}, nil, test.option)
+		if err != nil {
+			t.Error(err)
+		} else {
+			expectSolutions(t, test.name, iterator, true, test.expected...)
+		}
+		iterator.Close()
+	}
+}
+
+func TestPathQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, y, n := rdf.NewVariable("x"), rdf.NewVariable("y"), rdf.NewVariable("n")
+	typ := rdf.NewNamedNode("http://www.w3.org/1999/02/22-rdf-syntax-ns#type")
+	knows := rdf.NewNamedNode("http://schema.org/knows")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(x, typ, rdf.NewNamedNode("http://schema.org/Person"), nil),
+		rdf.NewQuad(y, rdf.NewNamedNode("http://schema.org/name"), n, nil),
+	}
+
+	iterator, err := styx.Query(pattern, []rdf.Term{x, y, n}, nil, WithPaths(ZeroOrMore(x, knows, y)))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Everyone else knows Jane, and everyone reaches themselves
+	expectSolutions(t, "people", iterator, true,
+		"<http://example.com/d1#b1> <http://example.com/d1#b1> \"John Doe\"",
+		"<http://example.com/d1#b1> <http://example.com/d1#b1> \"Johnny Doe\"",
+		"<http://example.com/d1#b1> <http://people.com/jane> \"Jane Doe\"",
+		"<http://people.com/jane> <http://people.com/jane> \"Jane Doe\"",
+		"<http://example.com/d2#b0> <http://people.com/jane> \"Jane Doe\"",
+		"<http://example.com/d2#b0> <http://example.com/d2#b0> \"Johnanthan Appleseed\"",
+	)
+	iterator.Close()
+
+	// a -> b -> c -> a is a cycle, c -> d leaves it, and e has no edges
+	node := func(name string) rdf.Term { return rdf.NewNamedNode("http://example.com/" + name) }
+	dataset := []*rdf.Quad{
+		rdf.NewQuad(node("a"), knows, node("b"), nil),
+		rdf.NewQuad(node("b"), knows, node("c"), nil),
+		rdf.NewQuad(node("c"), knows, node("a"), nil),
+		rdf.NewQuad(node("c"), knows, node("d"), nil),
+	}
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		dataset = append(dataset, rdf.NewQuad(node(name), typ, node("Node"), nil))
+	}
+
+	err = styx.Set(node("d3"), dataset)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	nodes := []*rdf.Quad{rdf.NewQuad(y, typ, node("Node"), nil)}
+	tests := []struct {
+		name     string
+		path     *Path
+		expected string
+	}{
+		{"cycle", OneOrMore(node("a"), knows, y), "abcd"},
+		{"cycle zero", ZeroOrMore(node("a"), knows, y), "abcd"},
+		{"sink", OneOrMore(node("d"), knows, y), ""},
+		{"sink zero", ZeroOrMore(node("d"), knows, y), "d"},
+		{"isolated zero", ZeroOrMore(node("e"), knows, y), "e"},
+		{"reverse", OneOrMore(y, knows, node("d")), "abc"},
+		{"reverse zero", ZeroOrMore(y, knows, node("d")), "abcd"},
+		{"reverse cycle", OneOrMore(y, knows, node("a")), "abc"},
+	}
+
+	for _, test := range tests {
+		iterator, err := styx.Query(nodes, []rdf.Term{y}, nil, WithPaths(test.path))
+		if err != nil {
+			t.Error(err)
+		} else {
+			expected := make([]string, len(test.expected))
+			for i, name := range test.expected {
+				expected[i] = node(string(name)).String()
+			}
+			expectSolutions(t, test.name, iterator, false, expected...)
+		}
+		iterator.Close()
+	}
+
+	// Paths between two constants only have to exist
+	ground := []struct {
+		path   *Path
+		exists bool
+	}{
+		{OneOrMore(node("a"), knows, node("a")), true},
+		{OneOrMore(node("d"), knows, node("d")), false},
+		{ZeroOrMore(node("d"), knows, node("d")), true},
+		{OneOrMore(node("b"), knows, node("d")), true},
+		{OneOrMore(node("d"), knows, node("b")), false},
+	}
+
+	for _, test := range ground {
+		iterator, err := styx.Query(nodes, []rdf.Term{y}, nil, WithPaths(test.path))
+		if err != nil {
+			t.Error(err)
+		} else if rows, err := solutions(iterator); err != nil {
+			t.Error(err)
+		} else if (len(rows) == 5) != test.exists || (len(rows) == 0) == test.exists {
+			t.Errorf("Unexpected solutions for %s: %v", test.path, rows)
+		}
+		iterator.Close()
+	}
+}
+
+func TestZeroLengthPathQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	node := func(name string) rdf.Term { return rdf.NewNamedNode("http://example.com/" + name) }
+	err := styx.Set(node("d1"), []*rdf.Quad{rdf.NewQuad(node("a"), node("p"), node("b"), nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Paths of length zero match subjects and objects, but not predicates
+	x, y := rdf.NewVariable("x"), rdf.NewVariable("y")
+	iterator, err := styx.Query(nil, []rdf.Term{x, y}, nil, WithPaths(ZeroOrMore(x, node("p"), y)))
+	defer iterator.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectSolutions(t, "zero length", iterator, false,
+		"<http://example.com/a> <http://example.com/a>",
+		"<http://example.com/a> <http://example.com/b>",
+		"<http://example.com/b> <http://example.com/b>",
+	)
+}
+
+func TestAggregateQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	xsd := "http://www.w3.org/2001/XMLSchema#"
+	score := rdf.NewNamedNode("http://example.com/score")
+	node := func(name string) rdf.Term { return rdf.NewNamedNode("http://example.com/" + name) }
+	literal := func(value, datatype string) rdf.Term {
+		return rdf.NewLiteral(value, "", rdf.NewNamedNode(xsd+datatype))
+	}
+	dataset := []*rdf.Quad{
+		rdf.NewQuad(node("a"), score, literal("1", "integer"), nil),
+		rdf.NewQuad(node("a"), score, literal("2", "integer"), nil),
+		rdf.NewQuad(node("a"), score, literal("6", "integer"), nil),
+		rdf.NewQuad(node("b"), score, literal("1.5", "decimal"), nil),
+		rdf.NewQuad(node("b"), score, literal("2", "integer"), nil),
+		rdf.NewQuad(node("c"), score, rdf.NewLiteral("x", "", nil), nil),
+	}
+
+	err := styx.Set(node("d3"), dataset)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	s, v := rdf.NewVariable("s"), rdf.NewVariable("v")
+	pattern := []*rdf.Quad{rdf.NewQuad(s, score, v, nil)}
+	aggregate := func(groups []rdf.Term, aggregates ...*Aggregate) []string {
+		iterator, err := styx.Query(pattern, []rdf.Term{s, v}, nil)
+		defer iterator.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rows, err := iterator.Aggregate(groups, aggregates...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := make([]string, len(rows))
+		for i, row := range rows {
+			result[i] = formatIndex(row)
+		}
+		sort.Strings(result)
+		return result
+	}
+
+	// Sums and averages of integers are integers and decimals, the sums and
+	// averages of other numbers are doubles, and they're unbound for strings.
+	expected := []string{
+		`<http://example.com/a> "3"^^<http://www.w3.org/2001/XMLSchema#integer> "1"^^<http://www.w3.org/2001/XMLSchema#integer> "6"^^<http://www.w3.org/2001/XMLSchema#integer> "9"^^<http://www.w3.org/2001/XMLSchema#integer> "3"^^<http://www.w3.org/2001/XMLSchema#decimal>`,
+		`<http://example.com/b> "2"^^<http://www.w3.org/2001/XMLSchema#integer> "1.5"^^<http://www.w3.org/2001/XMLSchema#decimal> "2"^^<http://www.w3.org/2001/XMLSchema#integer> "3.5E+00"^^<http://www.w3.org/2001/XMLSchema#double> "1.75E+00"^^<http://www.w3.org/2001/XMLSchema#double>`,
+		`<http://example.com/c> "1"^^<http://www.w3.org/2001/XMLSchema#integer> "x" "x" nil nil`,
+	}
+
+	actual := aggregate([]rdf.Term{s}, Count(v), Min(v), Max(v), Sum(v), Avg(v))
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Unexpected groups\n%s", strings.Join(actual, "\n"))
+	}
+
+	// "2" is a score of both a and b
+	actual = aggregate(nil, Count(nil), CountDistinct(v), CountDistinct(s))
+	if row := `"6"^^<http://www.w3.org/2001/XMLSchema#integer> "5"^^<http://www.w3.org/2001/XMLSchema#integer> "3"^^<http://www.w3.org/2001/XMLSchema#integer>`; len(actual) != 1 || actual[0] != row {
+		t.Errorf("Unexpected counts %v", actual)
+	}
+
+	// Without any solutions, there's still one row
+	pattern = []*rdf.Quad{rdf.NewQuad(s, node("nothing"), v, nil)}
+	actual = aggregate(nil, Count(nil), Sum(v))
+	if row := `"0"^^<http://www.w3.org/2001/XMLSchema#integer> "0"^^<http://www.w3.org/2001/XMLSchema#integer>`; len(actual) != 1 || actual[0] != row {
+		t.Errorf("Unexpected aggregates of no solutions %v", actual)
+	}
+}
+
+func TestCountQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, o := rdf.NewVariable("s"), rdf.NewVariable("o")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/name"), o, nil),
+	}
+
+	iterator, err := styx.Query(pattern, []rdf.Term{s, o}, nil)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	subjects, err := iterator.Count(s)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	err = iterator.Seek(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	names, err := iterator.Count(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	log.Printf("%d subjects, %d names\n", subjects, names)
+	if subjects != 3 || names != 4 {
+		t.Error("Unexpected counts")
+	}
+}
+
+func TestSPARQLQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query, iterator, err := styx.QuerySPARQL(`
+PREFIX schema: <http://schema.org/>
+PREFIX xsd: <http://www.w3.org/2001/XMLSchema#>
+SELECT ?name ?date WHERE {
+	?person a schema:Person ;
+		schema:name ?name ;
+		schema:birthDate ?date .
+	FILTER(?date > "1900-01-01"^^xsd:date && !REGEX(?name, "^johnny", "i"))
+} LIMIT 2`)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rows, err := query.Results(iterator)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, row := range rows {
+		log.Println(row)
+	}
+
+	if len(rows) != 2 {
+		t.Error("Unexpected number of results")
+	}
+
+	query, iterator, err = styx.QuerySPARQL(`ASK { <http://people.com/jane> <http://schema.org/name> "Jane Doe" }`)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rows, err = query.Results(iterator)
+	if err != nil {
+		t.Error(err)
+		return
+	} else if len(rows) != 1 {
+		t.Error("Expected ASK to be true")
+	}
+
+	_, err = ParseSPARQL(`SELECT ?s WHERE { ?s ?p ?o } ORDER BY ?s`)
+	log.Println(err)
+	if err == nil {
+		t.Error("Expected an error for ORDER BY")
+	}
+}
+
+func TestConstructQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, y, b := rdf.NewVariable("x"), rdf.NewVariable("y"), rdf.NewBlankNode("b")
+	knows := rdf.NewNamedNode("http://schema.org/knows")
+	pattern := []*rdf.Quad{rdf.NewQuad(x, knows, y, nil)}
+	template := []*rdf.Quad{
+		rdf.NewQuad(y, rdf.NewNamedNode("http://schema.org/knownBy"), x, nil),
+		rdf.NewQuad(y, rdf.NewNamedNode("http://schema.org/subjectOf"), b, nil),
+		rdf.NewQuad(y, rdf.NewNamedNode("http://www.w3.org/1999/02/22-rdf-syntax-ns#type"), rdf.NewNamedNode("http://schema.org/Person"), nil),
+	}
+
+	iterator, err := styx.Query(pattern, []rdf.Term{x, y}, nil)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	quads := []*rdf.Quad{}
+	err = iterator.Construct(template, func(quad *rdf.Quad) error {
+		quads = append(quads, quad)
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, quad := range quads {
+		log.Println(quad)
+	}
+
+	if len(quads) != 5 {
+		t.Error("Unexpected number of quads")
+		return
+	}
+
+	d3 := rdf.NewNamedNode("http://example.com/d3")
+	err = styx.Set(d3, quads)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := styx.Get(d3)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, quad := range result {
+		log.Println(quad)
+	}
+}
+
+func TestExplainQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iterator, err := styx.QueryJSONLD(`{
+	"@context": { "@vocab": "http://schema.org/" },
+	"@type": "Person",
+	"birthDate": { "@id": "?:foo" },
+	"name": { "@id": "?:bar" }
+}`)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	explanation := iterator.Explain()
+	for _, v := range explanation.Variables {
+		log.Printf("%s: norm %d, score %f, in %v, out %v\n", v.Node, v.Norm, v.Score, v.In, v.Out)
+		for _, c := range v.Constraints {
+			log.Printf("  %d p%d %s #%d\n", c.Index, c.Place, c.Prefix, c.Count)
+		}
+	}
+
+	if len(explanation.Variables) != len(iterator.Domain()) {
+		t.Error("Unexpected number of variables")
+	}
+}
+
+func TestPlannerQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	x, y, n := rdf.NewVariable("x"), rdf.NewVariable("y"), rdf.NewVariable("n")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(x, rdf.NewNamedNode("http://schema.org/knows"), y, nil),
+		rdf.NewQuad(y, rdf.NewNamedNode("http://schema.org/name"), n, nil),
+	}
+
+	results := make([][][]rdf.Term, 2)
+	for i, planner := range []Planner{CostPlanner, ScorePlanner} {
+		styx.Config.Planner = planner
+		iterator, err := styx.Query(pattern, nil, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		for _, v := range iterator.Explain().Variables {
+			log.Printf("%d: %s\n", i, v.Node)
+		}
+
+		results[i], err = iterator.Collect()
+		iterator.Close()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	if len(results[0]) != len(results[1]) || len(results[0]) == 0 {
+		t.Error("Planners found different solutions")
+	}
+}
+
+// fanoutPlanner keeps the order of the candidates and records one fanout
+type fanoutPlanner struct {
+	source, target rdf.Term
+	fanout         float64
+}
+
+func (p *fanoutPlanner) Plan(domain []rdf.Term, candidates []*Candidate, estimator Estimator) ([]int, error) {
+	fanout, err := estimator.Fanout(p.source, p.target)
+	if err != nil {
+		return nil, err
+	}
+	p.fanout = fanout
+
+	order := make([]int, len(candidates))
+	for i := range order {
+		order[i] = i
+	}
+	return order, nil
+}
+
+func TestPlannerSample(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	// The first half of the people know one person and the second half
+	// know twenty, so the fanout from a person is 10.5 on average.
+	knows := rdf.NewNamedNode("http://schema.org/knows")
+	node := func(format string, i int) rdf.Term {
+		return rdf.NewNamedNode(fmt.Sprintf("http://example.com/"+format, i))
+	}
+
+	dataset := []*rdf.Quad{}
+	for i := 0; i < 100; i++ {
+		if i < 50 {
+			dataset = append(dataset, rdf.NewQuad(node("p%d", i), knows, node("t%d", 0), nil))
+			continue
+		}
+		for j := 0; j < 20; j++ {
+			dataset = append(dataset, rdf.NewQuad(node("p%d", i), knows, node("t%d", j), nil))
+		}
+	}
+
+	err := styx.Set(rdf.NewNamedNode("http://example.com/people"), dataset)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	x, y := rdf.NewVariable("x"), rdf.NewVariable("y")
+	planner := &fanoutPlanner{source: x, target: y}
+	styx.Config.Planner = planner
+	iterator, err := styx.Query([]*rdf.Quad{rdf.NewQuad(x, knows, y, nil)}, nil, nil)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Sampling the first sixteen people would estimate a fanout of 1
+	if planner.fanout < 5 || planner.fanout > 16 {
+		t.Errorf("Expected a fanout near 10.5, got %f", planner.fanout)
+	}
+}
+
+func TestLimitQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, o := rdf.NewVariable("s"), rdf.NewVariable("o")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/name"), o, nil),
+	}
+
+	iterator, err := styx.Query(pattern, []rdf.Term{s, o}, nil, WithLimit(2))
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	skipped, err := iterator.Skip(1)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := iterator.Collect()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	for _, index := range result {
+		log.Println(index)
+	}
+
+	if skipped != 1 || len(result) != 2 {
+		t.Error("Unexpected number of solutions")
+	}
+}
+
+func TestParallelQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, p, o := rdf.NewVariable("s"), rdf.NewVariable("p"), rdf.NewVariable("o")
+	pattern := []*rdf.Quad{rdf.NewQuad(s, p, o, nil)}
+
+	sequential, err := styx.Query(pattern, []rdf.Term{s, p, o}, nil)
+	defer sequential.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	parallel, err := styx.Query(pattern, []rdf.Term{s, p, o}, nil, WithParallel(3))
+	defer parallel.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected, err := sequential.Collect()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	result, err := parallel.Collect()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(result) != len(expected) {
+		t.Error("Parallel query found a different number of solutions")
+		return
+	}
+
+	for i, index := range result {
+		log.Println(index)
+		if difference(index, expected[i]) != len(index) {
+			t.Error("Parallel query found solutions in a different order")
+		}
+	}
+
+	// Seeking into the middle of the solutions restarts every partition
+	err = sequential.Seek(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = sequential.Skip(len(expected) / 2)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	index := sequential.Index()[:1]
+	for _, iter := range []*Iterator{sequential, parallel} {
+		err = iter.Seek(index)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	expectedCount, err := sequential.Count(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	count, err := parallel.Count(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if count != expectedCount {
+		t.Error("Unexpected number of solutions after seeking")
+	}
+}
+
+// expectParallel checks that a parallel query finds the same solutions as the
+// sequential query in the same order, both from the start and after seeking
+// into the middle of them, and returns the number of solutions.
+func expectParallel(t *testing.T, styx *Store, name string, pattern []*rdf.Quad, domain []rdf.Term, options ...QueryOption) int {
+	sequential, err := styx.Query(pattern, domain, nil, options...)
+	defer sequential.Close()
+	if err != nil {
+		t.Errorf("%s: %s", name, err)
+		return 0
+	}
+
+	parallel, err := styx.Query(pattern, domain, nil, append(options, WithParallel(4))...)
+	defer parallel.Close()
+	if err != nil {
+		t.Errorf("%s: %s", name, err)
+		return 0
+	} else if len(parallel.partitions) < 2 {
+		t.Errorf("%s: expected the query to be partitioned", name)
+	}
+
+	expected, err := solutions(sequential)
+	if err != nil {
+		t.Errorf("%s: %s", name, err)
+		return 0
+	}
+
+	expectSolutions(t, name, parallel, true, expected...)
+
+	if err = sequential.Seek(nil); err != nil {
+		t.Errorf("%s: %s", name, err)
+		return 0
+	} else if _, err = sequential.Skip(len(expected) / 2); err != nil {
+		t.Errorf("%s: %s", name, err)
+		return 0
+	}
+
+	index := sequential.Index()[:2]
+	for _, iter := range []*Iterator{sequential, parallel} {
+		if err = iter.Seek(index); err != nil {
+			t.Errorf("%s: %s", name, err)
+			return 0
+		}
+	}
+
+	rest, err := solutions(sequential)
+	if err != nil {
+		t.Errorf("%s: %s", name, err)
+		return 0
+	}
+
+	expectSolutions(t, name+" after seeking", parallel, true, rest...)
+	return len(expected)
+}
+
+func TestParallelQueryOptions(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	// 300 people, split between three datasets, who each know
+	// two other people and have an age between 0 and 89
+	xsdInteger := rdf.NewNamedNode("http://www.w3.org/2001/XMLSchema#integer")
+	knows := rdf.NewNamedNode("http://schema.org/knows")
+	age := rdf.NewNamedNode("http://schema.org/age")
+	person := func(i int) rdf.Term { return rdf.NewNamedNode(fmt.Sprintf("http://people.com/%d", i%300)) }
+	integer := func(i int) rdf.Term { return rdf.NewLiteral(strconv.Itoa(i), "", xsdInteger) }
+
+	datasets := []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}
+	for j, dataset := range datasets {
+		quads := []*rdf.Quad{}
+		for i := j; i < 300; i += len(datasets) {
+			quads = append(quads,
+				rdf.NewQuad(person(i), knows, person(i*7+1), nil),
+				rdf.NewQuad(person(i), knows, person(i*13+5), nil),
+				rdf.NewQuad(person(i), age, integer(i%90), nil),
+			)
+		}
+
+		if err := styx.Set(rdf.NewNamedNode(dataset), quads); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+
+	s, o, a := rdf.NewVariable("s"), rdf.NewVariable("o"), rdf.NewVariable("a")
+	domain := []rdf.Term{s, o, a}
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, knows, o, nil),
+		rdf.NewQuad(o, age, a, nil),
+	}
+
+	if count := expectParallel(t, styx, "pattern", pattern, domain); count != 600 {
+		t.Errorf("Expected 600 solutions, got %d", count)
+	}
+
+	old, _ := Compare(Node(a), ">=", Node(integer(45)))
+	if count := expectParallel(t, styx, "filter", pattern, domain, WithFilters(old)); count != 270 {
+		t.Errorf("Expected 270 solutions with the filter, got %d", count)
+	}
+
+	// Remove the people that know someone with an age of zero
+	x := rdf.NewVariable("x")
+	zero := []*rdf.Quad{rdf.NewQuad(o, knows, x, nil), rdf.NewQuad(x, age, integer(0), nil)}
+	if count := expectParallel(t, styx, "not exists", pattern, domain, WithNotExists(zero)); count != 584 {
+		t.Errorf("Expected 584 solutions with NOT EXISTS, got %d", count)
+	}
+
+	newborn := []*rdf.Quad{rdf.NewQuad(s, age, integer(0), nil)}
+	if count := expectParallel(t, styx, "minus", pattern, domain, WithMinus(newborn)); count != 592 {
+		t.Errorf("Expected 592 solutions with MINUS, got %d", count)
+	}
+
+	source := WithSources(AllowDatasets(datasets[0], datasets[1]))
+	if count := expectParallel(t, styx, "sources", pattern, domain, source); count != 200 {
+		t.Errorf("Expected 200 solutions from the sources, got %d", count)
+	}
+
+	combined := []QueryOption{source, WithFilters(old), WithNotExists(zero)}
+	if count := expectParallel(t, styx, "combined", pattern, domain, combined...); count != 88 {
+		t.Errorf("Expected 88 solutions with every option, got %d", count)
+	}
+}
+
+func TestReverseQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, d := rdf.NewVariable("s"), rdf.NewVariable("d")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/birthDate"), d, nil),
+	}
+
+	xsdDate := rdf.NewNamedNode("http://www.w3.org/2001/XMLSchema#date")
+	after, _ := Compare(Node(d), ">=", Node(rdf.NewLiteral("1700-01-01", "", xsdDate)))
+	before, _ := Compare(Node(d), "<", Node(rdf.NewLiteral("1996-01-01", "", xsdDate)))
+
+	// The filters narrow the range of the dates, so Prev starts below the upper bound
+	iterator, err := styx.Query(pattern, []rdf.Term{d, s}, nil, WithFilters(And(after, before)))
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	expected := [][]rdf.Term{}
+	for delta, err := iterator.Next(nil); delta != nil; delta, err = iterator.Next(nil) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected = append(expected, iterator.Index())
+	}
+
+	err = iterator.SeekReverse(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	result := [][]rdf.Term{}
+	for delta, err := iterator.Prev(nil); delta != nil; delta, err = iterator.Prev(nil) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		index := iterator.Index()
+		log.Println(index)
+		result = append(result, index)
+	}
+
+	if len(result) != len(expected) || len(result) == 0 {
+		t.Error("Unexpected number of solutions in reverse")
+		return
+	}
+
+	for i, index := range result {
+		if difference(index, expected[len(expected)-1-i]) != len(index) {
+			t.Error("Reverse solutions are out of order")
+		}
+	}
+
+	// Next and Prev turn around at the current solution
+	err = iterator.Seek(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, _ = iterator.Next(nil)
+	_, _ = iterator.Next(nil)
+	delta, err := iterator.Prev(nil)
+	if err != nil {
+		t.Error(err)
+	} else if delta == nil || difference(iterator.Index(), expected[0]) != len(expected[0]) {
+		t.Error("Prev didn't return the previous solution")
+	}
+}
+
+func TestCursorQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, o := rdf.NewVariable("s"), rdf.NewVariable("o")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/name"), o, nil),
+	}
+
+	iterator, err := styx.Query(pattern, []rdf.Term{s, o}, nil)
+	if err != nil {
+		iterator.Close()
+		t.Error(err)
+		return
+	}
+
+	expected, err := iterator.Collect()
+	iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	// Each page resumes right after the last solution of the page before
+	result := [][]rdf.Term{}
+	options := []QueryOption{WithLimit(2)}
+	for {
+		iterator, err := styx.Query(pattern, []rdf.Term{s, o}, nil, options...)
+		if err != nil {
+			iterator.Close()
+			t.Error(err)
+			return
+		}
+
+		page, err := iterator.Collect()
+		if err != nil {
+			iterator.Close()
+			t.Error(err)
+			return
+		}
+
+		result = append(result, page...)
+		if len(page) < 2 {
+			iterator.Close()
+			break
+		}
+
+		cursor, err := iterator.Cursor()
+		iterator.Close()
+		if err == ErrEndOfSolutions {
+			break
+		} else if err != nil {
+			t.Error(err)
+			return
+		}
+
+		log.Println(cursor)
+		options = []QueryOption{WithLimit(2), WithCursor(cursor)}
+	}
+
+	if len(result) != len(expected) || len(result) == 0 {
+		t.Error("Unexpected number of solutions across pages")
+		return
+	}
+
+	for i, index := range result {
+		if difference(index, expected[i]) != len(index) {
+			t.Error("Pages are out of order")
+		}
+	}
+
+	// Cursors are rejected by other queries
+	iterator, err = styx.Query(pattern, []rdf.Term{s, o}, nil)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, _ = iterator.Next(nil)
+	cursor, err := iterator.Cursor()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = styx.Query(pattern, []rdf.Term{o, s}, nil, WithCursor(cursor))
+	if err != ErrInvalidCursor {
+		t.Error("Expected the cursor to be rejected")
+	}
+
+	// Cursors are only accepted by queries with the same options,
+	// except for the limit and the number of goroutines.
+	knows := rdf.NewNamedNode("http://schema.org/knows")
+	birthDate := rdf.NewNamedNode("http://schema.org/birthDate")
+	nobody := rdf.NewNamedNode("http://example.com/nobody")
+	b, k := rdf.NewVariable("b"), rdf.NewVariable("k")
+	compare := func(op, value string) QueryOption {
+		filter, _ := Compare(Node(o), op, Node(rdf.NewLiteral(value, "", nil)))
+		return WithFilters(filter)
+	}
+
+	variations := []struct {
+		name    string
+		options func() []QueryOption
+	}{
+		{"none", func() []QueryOption { return nil }},
+		{"filter", func() []QueryOption { return []QueryOption{compare("!=", "Nobody")} }},
+		{"other filter", func() []QueryOption { return []QueryOption{compare("<", "Nobody")} }},
+		{"optional", func() []QueryOption {
+			return []QueryOption{WithOptional([]*rdf.Quad{rdf.NewQuad(s, birthDate, b, nil)})}
+		}},
+		{"union", func() []QueryOption {
+			return []QueryOption{WithUnion(
+				[]*rdf.Quad{rdf.NewQuad(s, birthDate, b, nil)},
+				[]*rdf.Quad{rdf.NewQuad(s, knows, b, nil)},
+			)}
+		}},
+		{"not exists", func() []QueryOption {
+			return []QueryOption{WithNotExists([]*rdf.Quad{rdf.NewQuad(s, knows, nobody, nil)})}
+		}},
+		{"minus", func() []QueryOption {
+			return []QueryOption{WithMinus([]*rdf.Quad{rdf.NewQuad(s, knows, nobody, nil)})}
+		}},
+		{"path", func() []QueryOption { return []QueryOption{WithPaths(OneOrMore(s, knows, k))} }},
+		{"other path", func() []QueryOption { return []QueryOption{WithPaths(ZeroOrMore(s, knows, k))} }},
+		{"projection", func() []QueryOption { return []QueryOption{WithProjection(s)} }},
+		{"source", func() []QueryOption { return []QueryOption{WithSources(AllowDatasets(d1, d2))} }},
+		{"other source", func() []QueryOption { return []QueryOption{WithSources(AllowDatasets(d1))} }},
+		{"denied source", func() []QueryOption { return []QueryOption{WithSources(DenyDatasets(d2))} }},
+	}
+
+	for _, v := range variations {
+		iterator, err := styx.Query(pattern, []rdf.Term{s, o}, nil, v.options()...)
+		if err != nil {
+			iterator.Close()
+			t.Errorf("%s: %s", v.name, err)
+			continue
+		}
+
+		_, _ = iterator.Next(nil)
+		cursor, err := iterator.Cursor()
+		iterator.Close()
+		if err != nil {
+			t.Errorf("%s: %s", v.name, err)
+			continue
+		}
+
+		for _, w := range variations {
+			options := append(w.options(), WithLimit(1), WithParallel(2), WithCursor(cursor))
+			iterator, err := styx.Query(pattern, []rdf.Term{s, o}, nil, options...)
+			iterator.Close()
+			if v.name == w.name && err != nil {
+				t.Errorf("Expected a cursor from %s to be accepted: %s", v.name, err)
+			} else if v.name != w.name && err != ErrInvalidCursor {
+				t.Errorf("Expected a cursor from %s to be rejected by %s", v.name, w.name)
+			}
+		}
+	}
+}
+
+func TestProjectionQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, p, o := rdf.NewVariable("s"), rdf.NewVariable("p"), rdf.NewVariable("o")
+	pattern := []*rdf.Quad{rdf.NewQuad(s, p, o, nil)}
+
+	iterator, err := styx.Query(pattern, nil, nil)
+	if err != nil {
+		iterator.Close()
+		t.Error(err)
+		return
+	}
+
+	predicates := map[string]bool{}
+	for delta, err := iterator.Next(nil); delta != nil; delta, err = iterator.Next(nil) {
+		if err != nil {
+			iterator.Close()
+			t.Error(err)
+			return
+		}
+		predicates[iterator.Get(p).String()] = true
+	}
+	iterator.Close()
+
+	// The subjects and objects are existential, so every predicate occurs once
+	iterator, err = styx.Query(pattern, nil, nil, WithProjection(p))
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	result := map[string]bool{}
+	for delta, err := iterator.Next(nil); delta != nil; delta, err = iterator.Next(nil) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		value := iterator.Get(p).String()
+		log.Println(value)
+		if result[value] {
+			t.Error("Repeated predicate", value)
+		}
+		result[value] = true
+	}
+
+	if len(result) != len(predicates) || len(result) == 0 {
+		t.Error("Unexpected number of predicates")
+	}
+
+	query, iterator, err := styx.QuerySPARQL(`SELECT DISTINCT ?p WHERE { ?s ?p ?o }`)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	rows, err := query.Results(iterator)
+	if err != nil {
+		t.Error(err)
+	} else if len(rows) != len(predicates) {
+		t.Error("Unexpected number of distinct results")
+	}
+}
+
+func TestGroundQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jane, name := rdf.NewNamedNode("http://people.com/jane"), rdf.NewNamedNode("http://schema.org/name")
+	present := rdf.NewQuad(jane, name, rdf.NewLiteral("Jane Doe", "", nil), nil)
+	absent := rdf.NewQuad(jane, name, rdf.NewLiteral("Johnny Doe", "", nil), nil)
+
+	s, o := rdf.NewVariable("s"), rdf.NewVariable("o")
+	variable := rdf.NewQuad(s, name, o, nil)
+
+	tests := []struct {
+		pattern  []*rdf.Quad
+		solution bool
+	}{
+		{[]*rdf.Quad{variable, present}, true},
+		{[]*rdf.Quad{variable, absent}, false},
+		{[]*rdf.Quad{present}, true},
+		{[]*rdf.Quad{present, absent}, false},
+	}
+
+	for i, test := range tests {
+		iterator, err := styx.Query(test.pattern, nil, nil)
+		if err != nil {
+			iterator.Close()
+			t.Error(err)
+			return
+		}
+
+		delta, err := iterator.Next(nil)
+		iterator.Close()
+		if err != nil {
+			t.Error(err)
+		} else if (delta != nil) != test.solution {
+			t.Errorf("Unexpected solution for pattern %d", i)
+		}
+	}
+}
+
+func TestSourcesQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, o, n := rdf.NewVariable("s"), rdf.NewVariable("o"), rdf.NewVariable("n")
+	knows, name := rdf.NewNamedNode("http://schema.org/knows"), rdf.NewNamedNode("http://schema.org/name")
+	jane := rdf.NewNamedNode("http://people.com/jane")
+
+	tests := []struct {
+		pattern []*rdf.Quad
+		source  Source
+		count   int
+	}{
+		{[]*rdf.Quad{rdf.NewQuad(s, knows, jane, nil)}, nil, 2},
+		{[]*rdf.Quad{rdf.NewQuad(s, knows, jane, nil)}, AllowDatasets(d1), 1},
+		{[]*rdf.Quad{rdf.NewQuad(s, knows, jane, nil)}, DenyDatasets(d1), 1},
+		{[]*rdf.Quad{rdf.NewQuad(s, name, n, nil)}, AllowDatasets(d2), 1},
+		{[]*rdf.Quad{rdf.NewQuad(s, name, n, nil)}, AllowDatasets(d1, d2), 4},
+		// Jane's name is only in the first dataset
+		{[]*rdf.Quad{rdf.NewQuad(s, knows, o, nil), rdf.NewQuad(o, name, n, nil)}, nil, 2},
+		{[]*rdf.Quad{rdf.NewQuad(s, knows, o, nil), rdf.NewQuad(o, name, n, nil)}, AllowDatasets(d2), 0},
+		{[]*rdf.Quad{rdf.NewQuad(jane, name, rdf.NewLiteral("Jane Doe", "", nil), nil)}, AllowDatasets(d2), 0},
+		{[]*rdf.Quad{rdf.NewQuad(s, name, n, nil)}, SourceFunc(func(dataset string) bool { return false }), 0},
+	}
+
+	for i, test := range tests {
+		var options []QueryOption
+		if test.source != nil {
+			options = append(options, WithSources(test.source))
+		}
+
+		iterator, err := styx.Query(test.pattern, nil, nil, options...)
+		if err != nil {
+			iterator.Close()
+			t.Error(err)
+			return
+		}
+
+		count, err := iterator.Count(nil)
+		iterator.Close()
+		if err != nil {
+			t.Error(err)
+		} else if count != test.count {
+			t.Errorf("Unexpected number of solutions for pattern %d: %d", i, count)
+		}
+	}
+
+	// Provenance only includes the statements of the query's datasets
+	pattern := []*rdf.Quad{rdf.NewQuad(s, knows, jane, nil)}
+	iterator, err := styx.Query(pattern, nil, nil, WithSources(AllowDatasets(d2)))
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	_, err = iterator.Next(nil)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	prov, err := iterator.Prov()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(prov) != 1 || len(prov[0].Statements) != 1 || prov[0].Statements[0].Dataset.Value() != d2 {
+		t.Error("Unexpected provenance", prov)
+	}
+}
+
+func TestProvQuery(t *testing.T) {
+	styx := open()
+	defer styx.Close()
+
+	err := styx.SetJSONLD(d1, document1, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = styx.SetJSONLD(d2, document2, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, o, n := rdf.NewVariable("s"), rdf.NewVariable("o"), rdf.NewVariable("n")
+	person := rdf.NewNamedNode("http://schema.org/Person")
+	pattern := []*rdf.Quad{
+		rdf.NewQuad(s, rdf.NewNamedNode("http://schema.org/knows"), o, nil),
+		rdf.NewQuad(o, rdf.NewNamedNode("http://schema.org/name"), n, nil),
+		rdf.NewQuad(rdf.NewNamedNode("http://people.com/jane"), rdf.NewNamedNode("http://www.w3.org/1999/02/22-rdf-syntax-ns#type"), person, nil),
+	}
+
+	iterator, err := styx.Query(pattern, nil, nil)
+	defer iterator.Close()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	solutions := 0
+	for delta, err := iterator.Next(nil); delta != nil; delta, err = iterator.Next(nil) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		prov, err := iterator.Prov()
+		if err != nil {
+			t.Error(err)
+			return
+		} else if len(prov) != len(pattern) {
+			t.Error("Unexpected number of quads in the provenance")
+			return
+		}
+
+		for _, p := range prov {
+			if len(p.Statements) == 0 {
+				t.Error("Missing statements for quad", p.Quad)
+			}
+
+			for _, statement := range p.Statements {
+				log.Println(p.Quad, statement.URI, statement.Graph, statement.Dataset)
+				dataset := statement.Dataset.Value()
+				if dataset != d1 && dataset != d2 || !strings.HasPrefix(statement.URI, dataset+"/") {
+					t.Error("Unexpected statement", statement.URI)
+				}
+			}
+		}
+		solutions++
+	}
+
+	if solutions != 2 {
+		t.Error("Unexpected number of solutions")
+	}
+
+	_, err = iterator.Prov()
+	if err != ErrEndOfSolutions {
+		t.Error("Expected ErrEndOfSolutions after the last solution")
+	}
+}
+
+func TestFederatedQuery(t *testing.T) {
+	tags := NewPrefixTagScheme("http://example.com/")
+	stores := make([]*Store, 3)
+	for i := range stores {
+		store, err := NewMemoryStore(&Config{TagScheme: tags, Dictionary: StringDictionary})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+		stores[i] = store
+	}
+
+	// The first store has the first dataset, the second store has the
+	// second dataset, and the third store has both of them.
+	for i, documents := range [][]string{{d1}, {d2}, {d1, d2}} {
+		for _, dataset := range documents {
+			document := document1
+			if dataset == d2 {
+				document = document2
+			}
+			if err := stores[i].SetJSONLD(dataset, document, false); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	styx := open()
+	defer styx.Close()
+	if _, err := NewFederation(stores[0], styx); err != ErrFederation {
+		t.Error("Expected ErrFederation for a store with an IRI dictionary")
+	}
+
+	s, o, n := rdf.NewVariable("s"), rdf.NewVariable("o"), rdf.NewVariable("n")
+	knows, name := rdf.NewNamedNode("http://schema.org/knows"), rdf.NewNamedNode("http://schema.org/name")
+	patterns := [][]*rdf.Quad{
+		{rdf.NewQuad(s, knows, o, nil)},
+		{rdf.NewQuad(s, name, n, nil)},
+		{rdf.NewQuad(s, knows, o, nil), rdf.NewQuad(o, name, n, nil)},
+	}
+
+	collect := func(query func([]*rdf.Quad, []rdf.Term, []rdf.Term, ...QueryOption) (*Iterator, error), pattern []*rdf.Quad, d []rdf.Term) (domain []rdf.Term, solutions []string) {
+		iterator, err := query(pattern, d, nil)
+		defer iterator.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		domain = iterator.Domain()
+
+		for delta, err := iterator.Next(nil); delta != nil; delta, err = iterator.Next(nil) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			var solution []string
+			for _, term := range iterator.Index() {
+				solution = append(solution, term.String())
+			}
+			solutions = append(solutions, strings.Join(solution, " "))
+		}
+		return
+	}
+
+	// Federating the first two stores is the same as querying the third,
+	// and so is federating all three, since their shared triples are merged.
+	for _, federated := range [][]*Store{stores[:2], stores} {
+		federation, err := NewFederation(federated...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The federation's summed counts can lead the planner to
+		// a different order, so the queries are given the same domain.
+		for i, pattern := range patterns {
+			expectedDomain, expected := collect(stores[2].Query, pattern, nil)
+			domain, solutions := collect(federation.Query, pattern, expectedDomain)
+			if fmt.Sprint(domain) != fmt.Sprint(expectedDomain) {
+				t.Errorf("Unexpected domain for pattern %d: %v", i, domain)
+			} else if strings.Join(solutions, "\n") != strings.Join(expected, "\n") {
+				t.Errorf("Unexpected solutions for pattern %d: %v", i, solutions)
+			}
+
+			iterator, err := federation.Query(pattern, expectedDomain, nil)
+			if err != nil {
+				iterator.Close()
+				t.Fatal(err)
+			}
+
+			count, err := iterator.Count(nil)
+			if err != nil {
+				t.Error(err)
+			} else if count != len(expected) {
+				t.Errorf("Unexpected count for pattern %d: %d", i, count)
+			}
+
+			// Seeking back to the first solution finds it again
+			if len(expected) > 0 {
+				if err = iterator.Seek(nil); err != nil {
+					t.Error(err)
+				} else if _, err = iterator.Next(nil); err != nil {
+					t.Error(err)
+				} else if first := iterator.Index(); iterator.Seek(first) != nil {
+					t.Error("Unexpected error seeking to", first)
+				} else if delta, _ := iterator.Next(nil); delta == nil {
+					t.Errorf("Missing solution after seeking for pattern %d", i)
+				} else if index := iterator.Index(); index[0].String() != first[0].String() {
+					t.Errorf("Unexpected solution after seeking for pattern %d: %v", i, index)
+				}
+			}
+			iterator.Close()
+		}
+	}
+}
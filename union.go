@@ -0,0 +1,335 @@
+package styx
+
+import (
+	badger "github.com/dgraph-io/badger/v2"
+	rdf "github.com/underlay/go-rdfjs"
+)
+
+// WithUnion adds a union of alternative patterns to the query. The solutions of
+// the query are the solutions of the query's pattern joined with any one of the
+// alternatives. Variables that don't occur in an alternative are unbound in its
+// solutions, and blank nodes are local to each alternative.
+// Unlike UNION in SPARQL, the solutions are distinct: a solution of more than one
+// alternative is only returned once, like every other solution of an iterator.
+// Passing WithUnion more than once joins the query with every union.
+func WithUnion(patterns ...[]*rdf.Quad) QueryOption {
+	return func(options *queryOptions) {
+		options.unions = append(options.unions, patterns)
+	}
+}
+
+// newUnion returns an iterator that merges the solutions of every branch of the
+// query's unions. Each branch is an iterator over the query's pattern and one of
+// the alternatives of every union, and all the branches share the same domain,
+// so that their solutions can be merged in order.
+func newUnion(
+	query []*rdf.Quad,
+	domain []rdf.Term,
+	index []rdf.Term,
+	options *queryOptions,
+	tag TagScheme,
+	planner Planner,
+	txn view,
+	dictionary Dictionary,
+) (iter *Iterator, err error) {
+	iter = &Iterator{
+		query:      query,
+		ids:        map[string]int{},
+		tag:        tag,
+		planner:    planner,
+		txn:        txn,
+		dictionary: dictionary,
+	}
+
+	err = iter.project(options)
+	if err != nil {
+		return
+	}
+
+	// Distribute the query's pattern over the alternatives of every union
+	patterns := [][]*rdf.Quad{query}
+	for _, union := range options.unions {
+		next := make([][]*rdf.Quad, 0, len(patterns)*len(union))
+		for _, pattern := range patterns {
+			for _, alternative := range union {
+				branch := make([]*rdf.Quad, 0, len(pattern)+len(alternative))
+				branch = append(branch, pattern...)
+				next = append(next, append(branch, alternative...))
+			}
+		}
+		patterns = next
+	}
+
+	// The domain of the union is the given domain,
+	// followed by the rest of the visible variables in order.
+	nodes := make([]rdf.Term, 0, len(domain))
+	for _, node := range domain {
+		if node.TermType() != rdf.VariableType || iter.hidden(node) {
+			return nil, ErrInvalidDomain
+		}
+		nodes = append(nodes, node)
+	}
+
+	for _, pattern := range patterns {
+		for _, quad := range pattern {
+			for _, term := range quad {
+				if term.TermType() == rdf.VariableType && !iter.hidden(term) {
+					nodes = append(nodes, term)
+				}
+			}
+		}
+	}
+
+	for _, path := range options.paths {
+		for _, term := range []rdf.Term{path.subject, path.object} {
+			if term.TermType() == rdf.VariableType && !iter.hidden(term) {
+				nodes = append(nodes, term)
+			}
+		}
+	}
+
+	for _, node := range nodes {
+		if _, has := iter.ids[node.String()]; !has {
+			iter.ids[node.String()] = len(iter.domain)
+			iter.domain = append(iter.domain, node)
+		}
+	}
+
+	// Make sure that every node in the given domain is in one of the branches
+	for _, node := range domain {
+		if !mentions(query, node) && !mentionsAny(options.unions, node) && !mentionsPath(options.paths, node) {
+			return nil, ErrInvalidDomain
+		}
+	}
+
+	for _, filter := range options.filters {
+		for _, term := range filter.terms {
+			if _, has := iter.ids[term.String()]; !has && !iter.hidden(term) {
+				return nil, ErrInvalidFilter
+			}
+		}
+	}
+
+	branchOptions := &queryOptions{
+		filters:    options.filters,
+		optionals:  options.optionals,
+		negations:  options.negations,
+		paths:      options.paths,
+		projection: options.projection,
+		projected:  options.projected,
+		source:     options.source,
+	}
+
+	for _, pattern := range patterns {
+		branchDomain := make([]rdf.Term, 0, len(iter.domain))
+		for _, node := range iter.domain {
+			if mentions(pattern, node) || mentionsPath(options.paths, node) {
+				branchDomain = append(branchDomain, node)
+			}
+		}
+
+		var branch *Iterator
+		branch, err = newIterator(pattern, branchDomain, nil, branchOptions, tag, planner, txn, dictionary)
+		if err == badger.ErrKeyNotFound || err == ErrEmptyInterset || err == ErrNotFound || err == ErrInvalidFilter {
+			// Filters on variables that a branch doesn't have never pass
+			branch.release()
+			continue
+		} else if err != nil {
+			branch.release()
+			return
+		} else if branch.empty {
+			branch.release()
+			continue
+		}
+
+		iter.branches = append(iter.branches, branch)
+	}
+
+	for _, branch := range iter.branches {
+		for _, node := range branch.optionalDomain {
+			if _, has := iter.ids[node.String()]; !has {
+				iter.ids[node.String()] = len(iter.domain) + len(iter.optionalDomain)
+				iter.optionalDomain = append(iter.optionalDomain, node)
+			}
+		}
+	}
+
+	if len(iter.branches) == 0 {
+		iter.empty = true
+		return iter, nil
+	}
+
+	return iter, iter.Seek(index)
+}
+
+// tuple returns the IDs of the branch's values for the given nodes
+func (iter *Iterator) tuple(domain []rdf.Term) []ID {
+	tuple := make([]ID, len(domain))
+	for i, node := range domain {
+		if j, has := iter.ids[node.String()]; has && j < len(iter.variables) {
+			tuple[i] = iter.variables[j].value
+		}
+	}
+	return tuple
+}
+
+// row returns the branch's values for the union's domain
+func (iter *Iterator) row(branch *Iterator) []rdf.Term {
+	row := make([]rdf.Term, 0, len(iter.domain)+len(iter.optionalDomain))
+	for _, node := range iter.domain {
+		row = append(row, branch.Get(node))
+	}
+	for _, node := range iter.optionalDomain {
+		row = append(row, branch.Get(node))
+	}
+	return row
+}
+
+// compareTuples compares the first l IDs of two tuples
+func compareTuples(a, b []ID, l int) int {
+	for i := 0; i < l; i++ {
+		if a[i] < b[i] {
+			return -1
+		} else if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// setBranch sets iter.branch to the branch with the least solution.
+// The union is done when every branch is done.
+func (iter *Iterator) setBranch() {
+	iter.branch = -1
+	var min []ID
+	for i, branch := range iter.branches {
+		if branch.top || branch.empty {
+			continue
+		}
+
+		tuple := branch.tuple(iter.domain)
+		if iter.branch == -1 || compareTuples(tuple, min, len(iter.domain)) < 0 {
+			iter.branch, min = i, tuple
+		}
+	}
+
+	iter.top = iter.branch == -1
+}
+
+// advanceBranch moves the branch to its next solution
+// that differs from its current one at or before i.
+func (iter *Iterator) advanceBranch(branch *Iterator, i int) (err error) {
+	for ; i >= 0; i-- {
+		if _, has := branch.ids[iter.domain[i].String()]; has {
+			_, err = branch.Next(iter.domain[i])
+			return
+		}
+	}
+
+	// The branch has no variables at or before i,
+	// so all of its solutions are the same up to i.
+	branch.top = true
+	return
+}
+
+// nextUnion advances the iterator when it is a union of branches
+func (iter *Iterator) nextUnion(node rdf.Term) ([]rdf.Term, error) {
+	previous := iter.Index()
+	current := iter.branches[iter.branch]
+
+	i := len(iter.domain) - 1
+	if node != nil {
+		if j, has := iter.ids[node.String()]; has && j < len(iter.domain) {
+			i = j
+		} else {
+			node = nil
+		}
+	}
+
+	tuple := current.tuple(iter.domain)
+	for _, branch := range iter.branches {
+		if branch.top || branch.empty {
+			continue
+		}
+
+		if node == nil {
+			// Every branch at the same solution as the current
+			// branch moves on, so that solutions aren't repeated.
+			if branch != current && difference(previous, iter.row(branch)) < len(previous) {
+				continue
+			} else if _, err := branch.Next(nil); err != nil {
+				return nil, err
+			}
+		} else if compareTuples(branch.tuple(iter.domain), tuple, i+1) == 0 {
+			if err := iter.advanceBranch(branch, i); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	iter.setBranch()
+	if iter.top {
+		return nil, nil
+	}
+
+	index := iter.Index()
+	return index[difference(previous, index):], nil
+}
+
+// seekUnion seeks every branch to its first solution
+// that is greater than or equal to the index
+func (iter *Iterator) seekUnion(index []rdf.Term) (err error) {
+	if len(index) > len(iter.domain) {
+		return ErrInvalidIndex
+	}
+
+	terms := make([]ID, len(index))
+	for i, node := range index {
+		terms[i], err = iter.dictionary.GetID(node, rdf.Default)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, branch := range iter.branches {
+		// Branches that don't have one of the nodes in the index have a NIL
+		// value there, which is less than any term. So they have to seek past
+		// every solution that's equal to the index up until that node.
+		missing := len(index)
+		for i, node := range iter.domain[:len(index)] {
+			if _, has := branch.ids[node.String()]; !has {
+				missing = i
+				break
+			}
+		}
+
+		if err = branch.Seek(index[:missing]); err != nil {
+			return
+		}
+
+		// The branch's first solution is the current one, not the next one
+		branch.bot = false
+
+		if missing < len(index) && !branch.top {
+			if compareTuples(branch.tuple(iter.domain), terms, missing) == 0 {
+				if err = iter.advanceBranch(branch, missing-1); err != nil {
+					return
+				}
+			}
+		}
+	}
+
+	iter.setBranch()
+	return
+}
+
+func mentionsAny(unions [][][]*rdf.Quad, node rdf.Term) bool {
+	for _, union := range unions {
+		for _, pattern := range union {
+			if mentions(pattern, node) {
+				return true
+			}
+		}
+	}
+	return false
+}
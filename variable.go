@@ -16,6 +16,12 @@ type variable struct {
 	root  ID            // the first possible value for the variable, without joining on other variables
 	norm  uint64        // The sum of squares of key counts of constraints
 	score float64       // norm / size
+
+	filters []*Filter     // The filters whose last node is this variable
+	filter  func(ID) bool // Tests candidate values against the filters
+	lower   ID            // The least possible value allowed by the filters
+	upper   ID            // All the values allowed by the filters are less than upper
+	reverse bool          // Whether the variable's values are in descending order
 }
 
 func (u *variable) ID() ID {
@@ -55,12 +61,59 @@ func (u *variable) Sort() {
 
 // Seek to the next intersect value
 func (u *variable) Seek(value ID) ID {
-	return u.cs.Seek(value)
+	return u.pass(u.cs.Seek(value))
 }
 
 // Next returns the next intersect value
 func (u *variable) Next() ID {
-	return u.cs.Next()
+	return u.pass(u.cs.Next())
+}
+
+// pass skips over the values that fail the variable's filters
+func (u *variable) pass(value ID) ID {
+	for value != NIL {
+		if u.reverse && u.lower != NIL && value < u.lower {
+			return NIL
+		} else if !u.reverse && u.upper != NIL && value >= u.upper {
+			return NIL
+		} else if u.upper != NIL && value >= u.upper {
+			// Descending values can start at the upper bound itself
+		} else if u.filter == nil || u.filter(value) {
+			return value
+		}
+		value = u.cs.Next()
+	}
+	return NIL
+}
+
+// before returns true if a comes before b in the variable's order.
+// NIL comes before every other value in descending order.
+func (u *variable) before(a, b ID) bool {
+	if u.reverse {
+		return b != NIL && (a == NIL || a > b)
+	}
+	return a < b
+}
+
+// turn sets the direction of the variable's constraints, and moves them back
+// to the variable's current value. The root of the variable becomes the bound
+// of its range on the side that it starts from, since its constraints might
+// already have values pushed into them.
+func (u *variable) turn(reverse bool) {
+	u.reverse = reverse
+	for _, c := range u.cs {
+		c.turn(reverse)
+	}
+
+	if reverse {
+		u.root = u.upper
+	} else {
+		u.root = u.lower
+	}
+
+	if u.value != NIL {
+		u.value = u.Seek(u.value)
+	}
 }
 
 // caches is a slice of C structs
@@ -83,27 +136,14 @@ func (u *variable) save() *vcache {
 }
 
 func (g *Iterator) load(u *variable, vc *vcache) {
-	u.value = vc.ID
 	for _, d := range vc.caches {
-		c := u.edges[d.i][d.j]
-
-		v := g.variables[d.i]
-		m, n := (c.place+1)%3, (c.place+2)%3
-
-		p, other := c.place, c.place
-		if v.node.Equal(c.quad[m]) {
-			c.terms[m], p, other = vc.ID, m+3, n
-		} else if v.node.Equal(c.quad[n]) {
-			c.terms[n], p, other = vc.ID, n, m
-		}
-
-		if c.terms[other] == NIL {
-			c.prefix = assembleKey(BinaryPrefixes[p], true, vc.ID)
-		} else {
-			c.prefix = assembleKey(TernaryPrefixes[m], true, c.terms[m], c.terms[n])
-		}
+		u.edges[d.i][d.j].count = d.c
+	}
 
-		c.count = d.c
-		c.Seek(u.value)
+	// Re-position all of u's constraints at the saved value,
+	// since push reads the counts from their current items.
+	u.value = vc.ID
+	if u.value != NIL {
+		u.value = u.Seek(vc.ID)
 	}
 }
@@ -0,0 +1,219 @@
+package styx
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	badger "github.com/dgraph-io/badger/v2"
+)
+
+// A view is the read-only transaction that queries are evaluated in:
+// either a single badger transaction, or the union of several of them.
+type view interface {
+	Get(key []byte) (viewItem, error)
+	NewIterator(options badger.IteratorOptions) viewIterator
+	Discard()
+}
+
+// A viewItem is a key and its value. *badger.Item is a viewItem.
+type viewItem interface {
+	Key() []byte
+	KeyCopy(dst []byte) []byte
+	UserMeta() byte
+	Value(fn func(val []byte) error) error
+}
+
+// A viewIterator iterates over the keys of a view in order
+type viewIterator interface {
+	Seek(key []byte)
+	Next()
+	Valid() bool
+	ValidForPrefix(prefix []byte) bool
+	Item() viewItem
+	Close()
+}
+
+// badgerView is the view of a single badger transaction
+type badgerView struct{ txn *badger.Txn }
+
+func (v badgerView) Get(key []byte) (viewItem, error) {
+	item, err := v.txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (v badgerView) NewIterator(options badger.IteratorOptions) viewIterator {
+	return badgerIterator{v.txn.NewIterator(options)}
+}
+
+func (v badgerView) Discard() { v.txn.Discard() }
+
+type badgerIterator struct{ *badger.Iterator }
+
+func (iterator badgerIterator) Item() viewItem { return iterator.Iterator.Item() }
+
+// federatedView is the union of the views of several stores. The stores have to
+// give every term the same ID, so that their keys can be merged in order.
+type federatedView []view
+
+func (views federatedView) Get(key []byte) (viewItem, error) {
+	items := make(federatedItem, 0, len(views))
+	for _, v := range views {
+		item, err := v.Get(key)
+		if err == badger.ErrKeyNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+
+	if len(items) == 0 {
+		return nil, badger.ErrKeyNotFound
+	} else if len(items) == 1 {
+		return items[0], nil
+	}
+	return items, nil
+}
+
+func (views federatedView) NewIterator(options badger.IteratorOptions) viewIterator {
+	iterators := make([]viewIterator, len(views))
+	for i, v := range views {
+		iterators[i] = v.NewIterator(options)
+	}
+	return &federatedIterator{iterators: iterators, reverse: options.Reverse}
+}
+
+func (views federatedView) Discard() {
+	for _, v := range views {
+		v.Discard()
+	}
+}
+
+// federatedIterator merges the keys of the iterators of several views.
+// Keys that more than one view has are only visited once, with their values merged.
+type federatedIterator struct {
+	iterators []viewIterator
+	reverse   bool
+	current   []viewIterator // The iterators that are at the current key
+}
+
+// settle finds the iterators at the least key, or the greatest key in reverse
+func (iter *federatedIterator) settle() {
+	iter.current = iter.current[:0]
+	var key []byte
+	for _, iterator := range iter.iterators {
+		if !iterator.Valid() {
+			continue
+		}
+
+		k := iterator.Item().Key()
+		order := 0
+		if len(iter.current) > 0 {
+			order = bytes.Compare(k, key)
+			if iter.reverse {
+				order = -order
+			}
+		}
+
+		if len(iter.current) == 0 || order < 0 {
+			iter.current, key = append(iter.current[:0], iterator), k
+		} else if order == 0 {
+			iter.current = append(iter.current, iterator)
+		}
+	}
+}
+
+func (iter *federatedIterator) Seek(key []byte) {
+	for _, iterator := range iter.iterators {
+		iterator.Seek(key)
+	}
+	iter.settle()
+}
+
+func (iter *federatedIterator) Next() {
+	for _, iterator := range iter.current {
+		iterator.Next()
+	}
+	iter.settle()
+}
+
+func (iter *federatedIterator) Valid() bool { return len(iter.current) > 0 }
+
+func (iter *federatedIterator) ValidForPrefix(prefix []byte) bool {
+	return iter.Valid() && bytes.HasPrefix(iter.current[0].Item().Key(), prefix)
+}
+
+func (iter *federatedIterator) Item() viewItem {
+	if len(iter.current) == 1 {
+		return iter.current[0].Item()
+	}
+
+	items := make(federatedItem, len(iter.current))
+	for i, iterator := range iter.current {
+		items[i] = iterator.Item()
+	}
+	return items
+}
+
+func (iter *federatedIterator) Close() {
+	for _, iterator := range iter.iterators {
+		iterator.Close()
+	}
+}
+
+// federatedItem is a key that several views have. Its value is the
+// union of their values: the statements of a ternary key are
+// merged, and the counts of the other index keys are summed.
+type federatedItem []viewItem
+
+var newline = []byte{'\n'}
+
+func (items federatedItem) Key() []byte               { return items[0].Key() }
+func (items federatedItem) KeyCopy(dst []byte) []byte { return items[0].KeyCopy(dst) }
+func (items federatedItem) UserMeta() byte            { return items[0].UserMeta() }
+
+func (items federatedItem) Value(fn func(val []byte) error) error {
+	values := make([][]byte, len(items))
+	for i, item := range items {
+		err := item.Value(func(val []byte) error {
+			values[i] = append([]byte(nil), val...)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	key := items.Key()
+	switch {
+	case bytes.Equal(key, StatisticsKey), key[0] == UnaryPrefix, key[0] == GraphPrefix,
+		BinaryPrefixes[0] <= key[0] && key[0] <= BinaryPrefixes[5]:
+		// Counts are big-endian uint32s
+		sum := make([]byte, len(values[0]))
+		for _, val := range values {
+			for i := 0; i+4 <= len(val) && i+4 <= len(sum); i += 4 {
+				c := binary.BigEndian.Uint32(sum[i:]) + binary.BigEndian.Uint32(val[i:])
+				binary.BigEndian.PutUint32(sum[i:], c)
+			}
+		}
+		return fn(sum)
+	case TernaryPrefixes[0] <= key[0] && key[0] <= TernaryPrefixes[2]:
+		// Statements are lines, and a dataset that more than one
+		// store has only contributes its statements once.
+		result, lines := []byte{}, map[string]bool{}
+		for _, val := range values {
+			for _, line := range bytes.SplitAfter(val, newline) {
+				if len(line) > 0 && !lines[string(line)] {
+					lines[string(line)] = true
+					result = append(result, line...)
+				}
+			}
+		}
+		return fn(result)
+	default:
+		return fn(values[0])
+	}
+}